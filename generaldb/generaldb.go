@@ -21,29 +21,72 @@ import "gopkg.in/src-d/go-vitess.v0/mysql"
 import "gopkg.in/src-d/go-vitess.v0/sqltypes"
 import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
 import "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "github.com/a-mail-group/yoursql/querylog"
+import "github.com/a-mail-group/yoursql/metrics"
 import "fmt"
 import "io"
+import "time"
 
 var ESorry = fmt.Errorf("Sorry!")
 
+/* ER_TOO_BIG_SELECT */
+const erTooBigSelect = 1104
+
 type Gateway struct{
 	B Backend
+	
+	/* Optional query logger. */
+	Log querylog.Logger
+	
+	/* Optional metrics. */
+	Metrics *metrics.Metrics
+	
+	/*
+	Results are sent in chunks of about BatchBytes (default: 64 KiB).
+	Results larger than MaxResultBytes (if >0) fail with ER_TOO_BIG_SELECT.
+	*/
+	BatchBytes int
+	MaxResultBytes int64
 }
 func (g *Gateway) NewConnection(c *mysql.Conn) {
+	if g.Metrics!=nil { g.Metrics.ConnectionOpened() }
 	c.ClientData = NewPerClient(g.B)
 }
 func (g *Gateway) ConnectionClosed(c *mysql.Conn) {
+	if g.Metrics!=nil { g.Metrics.ConnectionClosed() }
 	c.ClientData.(*PerClient).Destroy()
 	c.ClientData = nil
 }
 func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	if g.Log==nil && g.Metrics==nil { return g.comQuery(c,query,callback) }
+	le := &querylog.Entry{Time:time.Now(),User:c.User,Schema:c.SchemaName,Query:query}
+	err := g.comQuery(c,query,func(r *sqltypes.Result) error {
+		le.Rows += r.RowsAffected
+		return callback(r)
+	})
+	le.Duration = time.Since(le.Time)
+	if err!=nil { le.Error = err.Error() }
+	if g.Metrics!=nil {
+		if err!=nil {
+			/* Re-parse only on failure, to tell translation failures from backend errors. */
+			if _,perr := sqlparser.Parse(query); perr!=nil || err==ESorry { g.Metrics.TranslationFailed() }
+		}
+		g.Metrics.Query(sqlparser.Preview(query),le.Duration,le.Rows,err)
+	}
+	if g.Log!=nil { g.Log.Log(le) }
+	return err
+}
+func (g *Gateway) comQuery(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
 	r,err := c.ClientData.(*PerClient).Query(c.SchemaName,query)
 	if err!=nil { return err }
 	if r.Closer!=nil { defer r.Close() }
 	
 	sr := new(sqltypes.Result)
 	
-	cnt := 0
+	batch := g.BatchBytes
+	if batch<=0 { batch = 64*1024 }
+	size,total := 0,int64(0)
 	sr.RowsAffected = r.RowsAffected
 	sr.InsertID = r.LastInsertId
 	sr.Fields = schemaToFields(r.Head)
@@ -60,15 +103,23 @@ func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Re
 			return err
 		}
 		
-		sr.Rows = append(sr.Rows, rowToSQL(r.Head, row))
-		sr.RowsAffected++
-		cnt++
+		vals := rowToSQL(r.Head, row)
+		rsize := 0
+		for _,v := range vals { rsize += len(v.Raw()) }
 		
-		if cnt>=256 {
+		total += int64(rsize)
+		if g.MaxResultBytes>0 && total>g.MaxResultBytes {
+			return mysql.NewSQLError(erTooBigSelect,"42000","The result exceeds the maximum result size of %d bytes",g.MaxResultBytes)
+		}
+		if size>0 && size+rsize>batch {
 			if err = callback(sr); err!=nil { return err }
 			sr = new(sqltypes.Result)
 			sr.Fields = schemaToFields(r.Head)
+			size = 0
 		}
+		sr.Rows = append(sr.Rows, vals)
+		sr.RowsAffected++
+		size += rsize
 	}
 	
 	eofun:
@@ -90,9 +141,18 @@ func schemaToFields(s sql.Schema) []*query.Field {
 		fields[i] = &query.Field{
 			Name: c.Name,
 			Type: c.Type.Type(),
+			Charset: fieldCharset(c.Type.Type()),
 		}
 	}
 
 	return fields
 }
 
+/*
+Like MySQL, text columns are sent as utf8 (33), everything else as binary (63).
+*/
+func fieldCharset(t query.Type) uint32 {
+	if sqltypes.IsText(t) || t==sqltypes.TypeJSON { return 33 }
+	return 63
+}
+
@@ -0,0 +1,249 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Metrics for the gateways (my2any and generaldb), exposed in the Prometheus text
+format.
+
+	m := metrics.New("my2any")
+	gw.Metrics = m
+	http.Handle("/metrics", metrics.Handler(m))
+*/
+package metrics
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "net/http"
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+
+/* The default buckets of the Prometheus client libraries. */
+var Buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct{
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+func (h *histogram) observe(v float64) {
+	if h.counts==nil { h.counts = make([]uint64,len(Buckets)) }
+	for i,b := range Buckets {
+		if v<=b { h.counts[i]++ }
+	}
+	h.sum += v
+	h.count++
+}
+
+/*
+The metrics of one gateway. All methods are safe for concurrent use.
+*/
+type Metrics struct{
+	/* The value of the "gateway" label. */
+	Gateway string
+	
+	/* Optional, returns the connection pools (by name) for the pool usage metrics. */
+	Pools func() map[string]*sql.DB
+	
+	lock        sync.Mutex
+	connections int64
+	connsTotal  uint64
+	queries     map[string]uint64
+	failures    uint64
+	errors      map[string]uint64
+	latency     map[string]*histogram
+	rows        uint64
+}
+func New(gateway string) *Metrics {
+	return &Metrics{
+		Gateway: gateway,
+		queries: make(map[string]uint64),
+		errors:  make(map[string]uint64),
+		latency: make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) ConnectionOpened() {
+	m.lock.Lock(); defer m.lock.Unlock()
+	m.connections++
+	m.connsTotal++
+}
+func (m *Metrics) ConnectionClosed() {
+	m.lock.Lock(); defer m.lock.Unlock()
+	m.connections--
+}
+
+/*
+Records a query, with the statement type as returned by sqlparser.Preview.
+*/
+func (m *Metrics) Query(pv int, d time.Duration, rows uint64, err error) {
+	typ := sqlparser.StmtType(pv)
+	m.lock.Lock(); defer m.lock.Unlock()
+	m.queries[typ]++
+	h := m.latency[typ]
+	if h==nil {
+		h = new(histogram)
+		m.latency[typ] = h
+	}
+	h.observe(d.Seconds())
+	m.rows += rows
+	if err!=nil { m.errors[SQLState(err)]++ }
+}
+
+/*
+Records a query, that couldn't be parsed or translated.
+*/
+func (m *Metrics) TranslationFailed() {
+	m.lock.Lock(); defer m.lock.Unlock()
+	m.failures++
+}
+
+/*
+Returns the SQLSTATE of an error, if it has one (like *mysql.SQLError or
+*pq.Error), otherwise HY000 (general error).
+*/
+func SQLState(err error) string {
+	if s,ok := err.(interface{ SQLState() string }); ok && s.SQLState()!="" { return s.SQLState() }
+	return "HY000"
+}
+
+func sortedKeys(mp map[string]uint64) []string {
+	keys := make([]string,0,len(mp))
+	for k := range mp { keys = append(keys,k) }
+	sort.Strings(keys)
+	return keys
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w,"# HELP %s %s\n# TYPE %s %s\n",name,help,name,typ)
+}
+
+/* A copy of the counters of a Metrics, taken under its lock. */
+type snapshot struct{
+	gateway     string
+	connections int64
+	connsTotal  uint64
+	queries     map[string]uint64
+	failures    uint64
+	errors      map[string]uint64
+	latency     map[string]histogram
+	rows        uint64
+	pools       map[string]sql.DBStats
+}
+func (m *Metrics) snapshot() *snapshot {
+	m.lock.Lock()
+	s := &snapshot{
+		gateway:     m.Gateway,
+		connections: m.connections,
+		connsTotal:  m.connsTotal,
+		queries:     make(map[string]uint64,len(m.queries)),
+		failures:    m.failures,
+		errors:      make(map[string]uint64,len(m.errors)),
+		latency:     make(map[string]histogram,len(m.latency)),
+		rows:        m.rows,
+	}
+	for k,v := range m.queries { s.queries[k] = v }
+	for k,v := range m.errors { s.errors[k] = v }
+	for k,h := range m.latency {
+		h2 := *h
+		h2.counts = append([]uint64(nil),h.counts...)
+		s.latency[k] = h2
+	}
+	pools := m.Pools
+	m.lock.Unlock()
+	
+	/* The pools have their own locking. */
+	if pools!=nil {
+		s.pools = make(map[string]sql.DBStats)
+		for name,db := range pools() { s.pools[name] = db.Stats() }
+	}
+	return s
+}
+
+/*
+Writes the metrics of all gateways in the Prometheus text format. The counters
+are copied first, so a slow writer doesn't block the gateways.
+*/
+func Write(w io.Writer, ms ...*Metrics) {
+	ss := make([]*snapshot,len(ms))
+	for i,m := range ms { ss[i] = m.snapshot() }
+	
+	header(w,"yoursql_connections","gauge","Open client connections.")
+	for _,s := range ss { fmt.Fprintf(w,"yoursql_connections{gateway=%q} %d\n",s.gateway,s.connections) }
+	
+	header(w,"yoursql_connections_total","counter","Accepted client connections.")
+	for _,s := range ss { fmt.Fprintf(w,"yoursql_connections_total{gateway=%q} %d\n",s.gateway,s.connsTotal) }
+	
+	header(w,"yoursql_queries_total","counter","Queries by statement type.")
+	for _,s := range ss {
+		for _,typ := range sortedKeys(s.queries) {
+			fmt.Fprintf(w,"yoursql_queries_total{gateway=%q,type=%q} %d\n",s.gateway,typ,s.queries[typ])
+		}
+	}
+	
+	header(w,"yoursql_translation_failures_total","counter","Queries, that couldn't be parsed or translated.")
+	for _,s := range ss { fmt.Fprintf(w,"yoursql_translation_failures_total{gateway=%q} %d\n",s.gateway,s.failures) }
+	
+	header(w,"yoursql_errors_total","counter","Failed queries by SQLSTATE.")
+	for _,s := range ss {
+		for _,st := range sortedKeys(s.errors) {
+			fmt.Fprintf(w,"yoursql_errors_total{gateway=%q,sqlstate=%q} %d\n",s.gateway,st,s.errors[st])
+		}
+	}
+	
+	header(w,"yoursql_query_duration_seconds","histogram","Query latency by statement type.")
+	for _,s := range ss {
+		types := make([]string,0,len(s.latency))
+		for typ := range s.latency { types = append(types,typ) }
+		sort.Strings(types)
+		for _,typ := range types {
+			h := s.latency[typ]
+			for i,b := range Buckets {
+				fmt.Fprintf(w,"yoursql_query_duration_seconds_bucket{gateway=%q,type=%q,le=\"%g\"} %d\n",s.gateway,typ,b,h.counts[i])
+			}
+			fmt.Fprintf(w,"yoursql_query_duration_seconds_bucket{gateway=%q,type=%q,le=\"+Inf\"} %d\n",s.gateway,typ,h.count)
+			fmt.Fprintf(w,"yoursql_query_duration_seconds_sum{gateway=%q,type=%q} %g\n",s.gateway,typ,h.sum)
+			fmt.Fprintf(w,"yoursql_query_duration_seconds_count{gateway=%q,type=%q} %d\n",s.gateway,typ,h.count)
+		}
+	}
+	
+	header(w,"yoursql_rows_total","counter","Rows streamed to or affected for the clients.")
+	for _,s := range ss { fmt.Fprintf(w,"yoursql_rows_total{gateway=%q} %d\n",s.gateway,s.rows) }
+	
+	header(w,"yoursql_pool_connections","gauge","Backend connections by pool and state.")
+	for _,s := range ss {
+		names := make([]string,0,len(s.pools))
+		for name := range s.pools { names = append(names,name) }
+		sort.Strings(names)
+		for _,name := range names {
+			st := s.pools[name]
+			fmt.Fprintf(w,"yoursql_pool_connections{gateway=%q,pool=%q,state=\"in_use\"} %d\n",s.gateway,name,st.InUse)
+			fmt.Fprintf(w,"yoursql_pool_connections{gateway=%q,pool=%q,state=\"idle\"} %d\n",s.gateway,name,st.Idle)
+		}
+	}
+}
+
+/*
+Returns a http.Handler serving the metrics of the gateways.
+*/
+func Handler(ms ...*Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type","text/plain; version=0.0.4; charset=utf-8")
+		Write(w,ms...)
+	})
+}
@@ -0,0 +1,246 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "regexp"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+
+const (
+	ERSpecificAccessDenied = 1227
+)
+
+/*
+Gateway-local admin statements. They are not MySQL syntax, so they are
+recognized before sqlparser.Preview.
+*/
+var adminRx = regexp.MustCompile(`^(?is)\s*(show\s+gateway\s+connections|show\s+gateway\s+cache|gateway\s+flush\s+metadata|gateway\s+explain\s+translation\s+(.*?))\s*;?\s*$`)
+
+/*
+The state of a client connection, as shown by SHOW GATEWAY CONNECTIONS. The
+mysql.Conn and its ClientData belong to the connection's goroutine, so the state
+is copied before and after every statement.
+*/
+type session struct{
+	id    uint32
+	host  string
+	since time.Time
+
+	user,schema string
+
+	/* The running statement, if any. */
+	query   string
+	started time.Time
+
+	tx,pinned   bool
+	locks       int
+	tableLocks  int
+	charset     string
+	lastWrite   time.Time
+}
+
+type sessions struct{
+	lock sync.Mutex
+	m    map[uint32]*session
+}
+func (s *sessions) add(c *mysql.Conn) {
+	s.lock.Lock(); defer s.lock.Unlock()
+	if s.m==nil { s.m = make(map[uint32]*session) }
+	se := &session{id:c.ConnectionID,since:time.Now()}
+	if a := c.RemoteAddr(); a!=nil { se.host = a.String() }
+	s.m[c.ConnectionID] = se
+}
+func (s *sessions) remove(c *mysql.Conn) {
+	s.lock.Lock(); defer s.lock.Unlock()
+	delete(s.m,c.ConnectionID)
+}
+func (s *sessions) start(c *mysql.Conn,query string) {
+	s.lock.Lock(); defer s.lock.Unlock()
+	se := s.m[c.ConnectionID]
+	if se==nil { return }
+	se.query,se.started = query,time.Now()
+	se.user,se.schema = c.User,c.SchemaName
+}
+func (s *sessions) done(c *mysql.Conn) {
+	cd,_ := c.ClientData.(*ClientData)
+	s.lock.Lock(); defer s.lock.Unlock()
+	se := s.m[c.ConnectionID]
+	if se==nil || cd==nil { return }
+	se.query = ""
+	se.user,se.schema = c.User,c.SchemaName
+	se.tx,se.pinned = cd.Tx!=nil,cd.Conn!=nil
+	se.locks,se.tableLocks = len(cd.locks),len(cd.tableLocks)
+	se.lastWrite = cd.LastWrite
+	if cd.client!=nil { se.charset = cd.client.Name }
+}
+
+/* A copy of the sessions, ordered by connection id. */
+func (s *sessions) list() []session {
+	s.lock.Lock(); defer s.lock.Unlock()
+	l := make([]session,0,len(s.m))
+	for _,se := range s.m { l = append(l,*se) }
+	sort.Slice(l,func(i,j int) bool { return l[i].id<l[j].id })
+	return l
+}
+
+func (g *Gateway) isAdmin(user string) bool {
+	for _,a := range g.Admins {
+		if a==user { return true }
+	}
+	return false
+}
+
+/*
+Implements the admin statements:
+
+	SHOW GATEWAY CONNECTIONS
+	SHOW GATEWAY CACHE
+	GATEWAY FLUSH METADATA
+	GATEWAY EXPLAIN TRANSLATION <statement>
+*/
+func (g *Gateway) admin(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	if !g.isAdmin(c.User) {
+		return mysql.NewSQLError(ERSpecificAccessDenied,"42000","Access denied; you need (at least one of) the GATEWAY ADMIN privilege(s) for this operation")
+	}
+	m := adminRx.FindStringSubmatch(query)
+	cmd := strings.ToLower(strings.Join(strings.Fields(m[1])," "))
+	switch {
+	case cmd=="show gateway connections":
+		return g.showConnections(callback)
+	case cmd=="show gateway cache":
+		return g.showCache(callback)
+	case cmd=="gateway flush metadata":
+		g.flushTables(c.SchemaName,"")
+		return callback(new(sqltypes.Result))
+	}
+	return g.explainTranslation(c,m[2],callback)
+}
+
+func sendRows(sch sqlv.Schema,rows [][]interface{},callback func(*sqltypes.Result) error) error {
+	sr := new(sqltypes.Result)
+	sr.Fields = schemaToFields(sch)
+	for _,row := range rows {
+		sr.Rows = append(sr.Rows,rowToSQL(sch,row))
+	}
+	sr.RowsAffected = uint64(len(sr.Rows))
+	return callback(sr)
+}
+
+func yesNo(b bool) string {
+	if b { return "Yes" }
+	return "No"
+}
+
+var connectionsSchema = sqlv.Schema{
+	{Name:"Id",Type:sqlv.Int64},
+	{Name:"User",Type:sqlv.Text},
+	{Name:"Host",Type:sqlv.Text},
+	{Name:"Db",Type:sqlv.Text},
+	{Name:"Connected",Type:sqlv.Int64},
+	{Name:"Time",Type:sqlv.Int64},
+	{Name:"Info",Type:sqlv.Text},
+	{Name:"Transaction",Type:sqlv.Text},
+	{Name:"Pinned",Type:sqlv.Text},
+	{Name:"Named_locks",Type:sqlv.Int64},
+	{Name:"Locked_tables",Type:sqlv.Int64},
+	{Name:"Charset",Type:sqlv.Text},
+	{Name:"Last_write",Type:sqlv.Text},
+}
+
+/*
+Lists the client connections, like SHOW PROCESSLIST, with their transaction
+and lock state. Connected and Time (of the running statement) are in seconds.
+*/
+func (g *Gateway) showConnections(callback func(*sqltypes.Result) error) error {
+	now := time.Now()
+	var rows [][]interface{}
+	for _,se := range g.sessions.list() {
+		running := int64(0)
+		if se.query!="" { running = int64(now.Sub(se.started)/time.Second) }
+		lastWrite := ""
+		if !se.lastWrite.IsZero() { lastWrite = se.lastWrite.Format("2006-01-02 15:04:05") }
+		rows = append(rows,[]interface{}{
+			int64(se.id),se.user,se.host,se.schema,
+			int64(now.Sub(se.since)/time.Second),running,se.query,
+			yesNo(se.tx),yesNo(se.pinned),int64(se.locks),int64(se.tableLocks),
+			se.charset,lastWrite,
+		})
+	}
+	return sendRows(connectionsSchema,rows,callback)
+}
+
+var cacheSchema = sqlv.Schema{
+	{Name:"Cache",Type:sqlv.Text},
+	{Name:"Size",Type:sqlv.Int64},
+	{Name:"Capacity",Type:sqlv.Int64},
+	{Name:"Hits",Type:sqlv.Uint64},
+	{Name:"Misses",Type:sqlv.Uint64},
+	{Name:"Invalidations",Type:sqlv.Uint64},
+}
+
+/*
+Shows the statistics of the statement cache (no rows without a cache).
+*/
+func (g *Gateway) showCache(callback func(*sqltypes.Result) error) error {
+	var rows [][]interface{}
+	if g.Cache!=nil {
+		s := g.Cache.Stats()
+		rows = append(rows,[]interface{}{"statements",int64(s.Size),int64(s.Capacity),s.Hits,s.Misses,s.Invalidations})
+	}
+	return sendRows(cacheSchema,rows,callback)
+}
+
+var stmtNames = map[int]string{
+	sqlparser.StmtSelect: "SELECT",
+	sqlparser.StmtInsert: "INSERT",
+	sqlparser.StmtReplace: "REPLACE",
+	sqlparser.StmtUpdate: "UPDATE",
+	sqlparser.StmtDelete: "DELETE",
+	sqlparser.StmtDDL: "DDL",
+	StmtxInsertReturning: "INSERT RETURNING",
+}
+
+var explainSchema = sqlv.Schema{
+	{Name:"Type",Type:sqlv.Text},
+	{Name:"Translation",Type:sqlv.Text},
+}
+
+/*
+Translates the statement like the gateway would, including the firewall and
+the rewrite rules, without executing it. The statement cache isn't touched.
+*/
+func (g *Gateway) explainTranslation(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	pv := sqlparser.Preview(query)
+	/* Not through the StmtCache, which would store and count the statement. */
+	st,err := decodeSql(query)
+	if err!=nil { return err }
+	if g.Firewall!=nil {
+		if _,err = g.Firewall.Check(c.User,c.SchemaName,st); err!=nil { return err }
+	}
+	_,nq,err := g.encode(c,query,st,&pv)
+	if err!=nil { return err }
+	name,ok := stmtNames[pv]
+	if !ok { name = "OTHER" }
+	return sendRows(explainSchema,[][]interface{}{{name,nq}},callback)
+}
@@ -0,0 +1,261 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "regexp"
+import "strings"
+import "time"
+import "fmt"
+
+const (
+	ERSpBadSelect = 1312
+	ERSpDoesNotExist = 1305
+)
+
+const (
+	serverStatusInTrans = 0x0001
+	serverStatusAutocommit = 0x0002
+	serverMoreResultsExists = 0x0008
+)
+
+var callRx = regexp.MustCompile("^(?is)\\s*call\\s+((?:`[^`]+`|[\\w$]+)(?:\\s*\\.\\s*(?:`[^`]+`|[\\w$]+))?)\\s*(?:\\((.*)\\))?\\s*;?\\s*$")
+
+/*
+Optional interface for SpecialFeatures, that implement CALL.
+
+Call executes the procedure (or function) proc in the schema (if proc isn't
+qualified). The arguments are preprocessed, OUT parameters (user variables like
+@x in MySQL) are NULL. If params is false, rs is the result set of a function.
+Otherwise, rs is the (single) row of the procedure's OUT parameters, cursors
+among them (see Cursor) are fetched with Fetch and returned as result sets.
+*/
+type Caller interface{
+	Call(tx *sql.Tx, schema string, proc sqlparser.TableName, args sqlparser.SelectExprs) (rs *sql.Rows, params bool, err error)
+	Cursor(ct *sql.ColumnType) bool
+	Fetch(tx *sql.Tx, cursor string) (*sql.Rows,error)
+}
+
+/*
+Parses CALL proc[([arg [, arg] ...])].
+*/
+func parseCall(query string) (proc sqlparser.TableName,args sqlparser.SelectExprs,err error) {
+	m := callRx.FindStringSubmatch(query)
+	if m==nil { return proc,nil,fmt.Errorf("syntax error in CALL statement") }
+	names := strings.SplitN(m[1],".",2)
+	for i := range names { names[i] = strings.Trim(strings.TrimSpace(names[i]),"`") }
+	proc.Name = sqlparser.NewTableIdent(names[len(names)-1])
+	if len(names)==2 { proc.Qualifier = sqlparser.NewTableIdent(names[0]) }
+	if strings.TrimSpace(m[2])=="" { return }
+	st,err := decodeSql("select "+m[2])
+	if err!=nil { return }
+	sel,ok := st.(*sqlparser.Select)
+	if !ok { return proc,nil,fmt.Errorf("syntax error in CALL statement") }
+	args = sel.SelectExprs
+	for _,se := range args {
+		ae,ok := se.(*sqlparser.AliasedExpr)
+		if !ok { return proc,nil,fmt.Errorf("syntax error in CALL statement") }
+		if cn,ok := ae.Expr.(*sqlparser.ColName); ok && strings.HasPrefix(cn.Name.String(),"@") {
+			ae.Expr = &sqlparser.NullVal{}
+		}
+	}
+	return
+}
+
+/*
+Implements CALL: Every result set is sent to the client, followed by the OUT
+parameters (if any) and the final OK packet, like MySQL does. Outside of a
+transaction, the procedure runs in a transaction of its own, as cursors only
+live until the end of the transaction.
+*/
+func (g *Gateway) call(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	cl,ok := g.SF.(Caller)
+	if !ok { return fmt.Errorf("CALL is not supported by this backend") }
+	proc,args,err := parseCall(query)
+	if err!=nil { return err }
+	if g.Firewall!=nil {
+		if _,err = g.Firewall.Check(c.User,c.SchemaName,&sqlparser.OtherAdmin{}); err!=nil { return err }
+	}
+	sel := &sqlparser.Select{SelectExprs:args}
+	g.Syn.Preprocess(sel,c.SchemaName)
+
+	cd := c.ClientData.(*ClientData)
+	tx := cd.Tx
+	if tx==nil {
+		if tx,err = g.begin(cd); err!=nil { return err }
+	}
+	status := uint16(serverStatusAutocommit)
+	if cd.Tx!=nil { status = serverStatusInTrans }
+	err = g.callIn(c,cl,tx,proc,sel.SelectExprs,status)
+	if cd.Tx==nil {
+		if err==nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		g.unpin(cd)
+	}
+	if err!=nil { return err }
+	cd.LastWrite = time.Now()
+	return callback(new(sqltypes.Result))
+}
+func (g *Gateway) callIn(c *mysql.Conn,cl Caller,tx *sql.Tx,proc sqlparser.TableName,args sqlparser.SelectExprs,status uint16) error {
+	rs,params,err := cl.Call(tx,c.SchemaName,proc,args)
+	if err!=nil { return err }
+	if !params { return g.sendResultSet(c,proc,rs,status) }
+
+	defer rs.Close()
+	cts,err := rs.ColumnTypes()
+	if err!=nil { return err }
+	var sch sqlv.Schema
+	var vls []interface{}
+	var cursors []*sql.NullString
+	sca := make([]interface{},len(cts))
+	for i,ct := range cts {
+		if cl.Cursor(ct) {
+			cur := new(sql.NullString)
+			sca[i] = cur
+			cursors = append(cursors,cur)
+			continue
+		}
+		var col *sqlv.Column
+		col,sca[i] = g.CC.Convert(ct)
+		sch = append(sch,col)
+	}
+	if !rs.Next() { return rs.Err() }
+	if err = rs.Scan(sca...); err!=nil { return err }
+	for i,ct := range cts {
+		if !cl.Cursor(ct) { vls = append(vls,deref(sca[i])) }
+	}
+	rs.Close()
+
+	for _,cur := range cursors {
+		if !cur.Valid { continue }
+		crs,err := cl.Fetch(tx,cur.String)
+		if err!=nil { return err }
+		if err = g.sendResultSet(c,proc,crs,status); err!=nil { return err }
+	}
+	if len(sch)==0 { return nil }
+	if c.Capabilities&mysql.CapabilityClientMultiResults==0 { return spBadSelect(proc) }
+	sr := &sqltypes.Result{Fields:schemaToFields(sch),Rows:[][]sqltypes.Value{rowToSQL(sch,vls)}}
+	err = c.ClientData.(*ClientData).encodeResults(func(sr *sqltypes.Result) error {
+		if err := writeFields(c,sr.Fields,status); err!=nil { return err }
+		return writeRows(c,sr.Rows)
+	})(sr)
+	if err!=nil { return err }
+	return writeEnd(c,status|serverMoreResultsExists)
+}
+
+func spBadSelect(proc sqlparser.TableName) error {
+	return mysql.NewSQLError(ERSpBadSelect,"0A000","PROCEDURE %s can't return a result set in the given context",sqlparser.String(proc))
+}
+
+/*
+Sends a result set of a procedure. The go-vitess handler can only send one
+result set per query, so the packets are written directly, with the flag
+SERVER_MORE_RESULTS_EXISTS set. The final OK packet is sent by the handler.
+*/
+func (g *Gateway) sendResultSet(c *mysql.Conn,proc sqlparser.TableName,rs *sql.Rows,status uint16) error {
+	if c.Capabilities&mysql.CapabilityClientMultiResults==0 {
+		rs.Close()
+		return spBadSelect(proc)
+	}
+	first := true
+	err := g.streamRows(c,rs,c.ClientData.(*ClientData).encodeResults(func(sr *sqltypes.Result) error {
+		if first {
+			first = false
+			if err := writeFields(c,sr.Fields,status); err!=nil { return err }
+		}
+		return writeRows(c,sr.Rows)
+	}))
+	if err!=nil { return err }
+	return writeEnd(c,status|serverMoreResultsExists)
+}
+
+func lenEncInt(b []byte,i uint64) []byte {
+	switch {
+	case i<251: return append(b,byte(i))
+	case i<1<<16: return append(b,0xfc,byte(i),byte(i>>8))
+	case i<1<<24: return append(b,0xfd,byte(i),byte(i>>8),byte(i>>16))
+	}
+	return append(b,0xfe,byte(i),byte(i>>8),byte(i>>16),byte(i>>24),byte(i>>32),byte(i>>40),byte(i>>48),byte(i>>56))
+}
+func lenEncString(b []byte,s []byte) []byte {
+	return append(lenEncInt(b,uint64(len(s))),s...)
+}
+
+/*
+Writes the column count, the column definitions and (unless the client uses
+CLIENT_DEPRECATE_EOF) an EOF packet.
+*/
+func writeFields(c *mysql.Conn,fields []*query.Field,status uint16) error {
+	if err := c.WritePacket(lenEncInt(nil,uint64(len(fields)))); err!=nil { return err }
+	for _,f := range fields {
+		typ,flags := sqltypes.TypeToMySQL(f.Type)
+		charset := f.Charset
+		if charset==0 {
+			charset = 63 /* binary */
+			if sqltypes.IsText(f.Type) { charset = 33 /* utf8_general_ci */ }
+		}
+		p := lenEncString(nil,[]byte("def"))
+		p = lenEncString(p,[]byte(f.Database))
+		p = lenEncString(p,[]byte(f.Table))
+		p = lenEncString(p,[]byte(f.OrgTable))
+		p = lenEncString(p,[]byte(f.Name))
+		p = lenEncString(p,[]byte(f.OrgName))
+		p = append(p,0x0c,byte(charset),byte(charset>>8))
+		p = append(p,byte(f.ColumnLength),byte(f.ColumnLength>>8),byte(f.ColumnLength>>16),byte(f.ColumnLength>>24))
+		fl := uint32(flags)|f.Flags
+		p = append(p,byte(typ),byte(fl),byte(fl>>8),byte(f.Decimals),0,0)
+		if err := c.WritePacket(p); err!=nil { return err }
+	}
+	if c.Capabilities&mysql.CapabilityClientDeprecateEOF==0 {
+		return c.WritePacket([]byte{0xfe,0,0,byte(status),byte(status>>8)})
+	}
+	return nil
+}
+func writeRows(c *mysql.Conn,rows [][]sqltypes.Value) error {
+	for _,row := range rows {
+		var p []byte
+		for _,v := range row {
+			if v.IsNull() {
+				p = append(p,0xfb)
+			} else {
+				p = lenEncString(p,v.Raw())
+			}
+		}
+		if err := c.WritePacket(p); err!=nil { return err }
+	}
+	return nil
+}
+
+/*
+Writes the EOF packet (or the OK packet, that replaces it with CLIENT_DEPRECATE_EOF)
+at the end of a result set.
+*/
+func writeEnd(c *mysql.Conn,status uint16) error {
+	if c.Capabilities&mysql.CapabilityClientDeprecateEOF==0 {
+		return c.WritePacket([]byte{0xfe,0,0,byte(status),byte(status>>8)})
+	}
+	return c.WritePacket([]byte{0xfe,0,0,byte(status),byte(status>>8),0,0})
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "testing"
+
+func TestParseCall(t *testing.T) {
+	cases := []struct{
+		query, proc, args string
+		ok bool
+	}{
+		{"CALL p","p","",true},
+		{"call p();","p","",true},
+		{"call s.`my proc`(1, @x, 'a')","s.`my proc`","1, null, 'a'",true},
+		{"CALL p(a + 1, concat('x', 'y'))","p","a + 1, concat('x', 'y')",true},
+		{"call","","",false},
+		{"call p(1","","",false},
+		{"call p(1))","","",false},
+	}
+	for _,c := range cases {
+		proc,args,err := parseCall(c.query)
+		if (err==nil)!=c.ok {
+			t.Errorf("parseCall(%q): error %v, want ok %v",c.query,err,c.ok)
+			continue
+		}
+		if !c.ok { continue }
+		if sqlparser.String(proc)!=c.proc || sqlparser.String(args)!=c.args {
+			t.Errorf("parseCall(%q) = %q, %q; want %q, %q",c.query,sqlparser.String(proc),sqlparser.String(args),c.proc,c.args)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "golang.org/x/text/encoding"
+import "golang.org/x/text/encoding/charmap"
+import "golang.org/x/text/encoding/japanese"
+import "golang.org/x/text/encoding/korean"
+import "golang.org/x/text/encoding/simplifiedchinese"
+import "golang.org/x/text/encoding/traditionalchinese"
+import "regexp"
+import "strings"
+import "unicode/utf8"
+
+const (
+	ERUnknownCharacterSet = 1115
+	ERInvalidCharacterString = 1300
+)
+
+const (
+	collationBinary = 63
+	collationUtf8 = 33
+)
+
+/*
+A MySQL character set. The backend is expected to deliver UTF-8, so Enc is
+nil for the character sets, that need no conversion (UTF-8 and subsets of it).
+*/
+type Charset struct{
+	Name string
+	Enc  encoding.Encoding
+
+	/*
+	The trail bytes of multi-byte characters can be ASCII (like 0x5C, the
+	backslash, in SJIS), which the tokenizer would misread. Queries are
+	decoded as a whole then.
+	*/
+	ASCIITrail bool
+
+	/* The collation ids (as used in the handshake), the first one is the default. */
+	Collations []uint8
+}
+
+func collationRange(from, to uint8) (ids []uint8) {
+	for id := from; id<=to; id++ { ids = append(ids,id) }
+	return
+}
+
+var Charsets = map[string]*Charset{
+	"utf8":    {Name:"utf8",Collations:append([]uint8{33,83},collationRange(192,215)...)},
+	"utf8mb4": {Name:"utf8mb4",Collations:append([]uint8{45,46},append(collationRange(224,247),255)...)},
+	"ascii":   {Name:"ascii",Collations:[]uint8{11,65}},
+	"binary":  {Name:"binary",Collations:[]uint8{collationBinary}},
+	/* MySQL's latin1 is actually cp1252. */
+	"latin1":  {Name:"latin1",Enc:charmap.Windows1252,Collations:[]uint8{8,5,15,31,47,48,49,94}},
+	"latin2":  {Name:"latin2",Enc:charmap.ISO8859_2,Collations:[]uint8{9,2,21,27,77}},
+	"latin5":  {Name:"latin5",Enc:charmap.ISO8859_9,Collations:[]uint8{30,78}},
+	"latin7":  {Name:"latin7",Enc:charmap.ISO8859_13,Collations:[]uint8{41,20,42,79}},
+	"greek":   {Name:"greek",Enc:charmap.ISO8859_7,Collations:[]uint8{25,70}},
+	"hebrew":  {Name:"hebrew",Enc:charmap.ISO8859_8,Collations:[]uint8{16,71}},
+	"cp1250":  {Name:"cp1250",Enc:charmap.Windows1250,Collations:[]uint8{26,34,44,66,99}},
+	"cp1251":  {Name:"cp1251",Enc:charmap.Windows1251,Collations:[]uint8{51,14,23,50,52}},
+	"cp1256":  {Name:"cp1256",Enc:charmap.Windows1256,Collations:[]uint8{57,67}},
+	"cp1257":  {Name:"cp1257",Enc:charmap.Windows1257,Collations:[]uint8{59,29,58}},
+	"cp850":   {Name:"cp850",Enc:charmap.CodePage850,Collations:[]uint8{4,80}},
+	"cp852":   {Name:"cp852",Enc:charmap.CodePage852,Collations:[]uint8{40,81}},
+	"cp866":   {Name:"cp866",Enc:charmap.CodePage866,Collations:[]uint8{36,68}},
+	"koi8r":   {Name:"koi8r",Enc:charmap.KOI8R,Collations:[]uint8{7,74}},
+	"koi8u":   {Name:"koi8u",Enc:charmap.KOI8U,Collations:[]uint8{22,75}},
+	"sjis":    {Name:"sjis",Enc:japanese.ShiftJIS,ASCIITrail:true,Collations:[]uint8{13,88}},
+	"cp932":   {Name:"cp932",Enc:japanese.ShiftJIS,ASCIITrail:true,Collations:[]uint8{95,96}},
+	"ujis":    {Name:"ujis",Enc:japanese.EUCJP,Collations:[]uint8{12,91}},
+	"eucjpms": {Name:"eucjpms",Enc:japanese.EUCJP,Collations:[]uint8{97,98}},
+	"euckr":   {Name:"euckr",Enc:korean.EUCKR,Collations:[]uint8{19,85}},
+	"gbk":     {Name:"gbk",Enc:simplifiedchinese.GBK,ASCIITrail:true,Collations:[]uint8{28,87}},
+	"gb2312":  {Name:"gb2312",Enc:simplifiedchinese.GBK,Collations:[]uint8{24,86}},
+	"gb18030": {Name:"gb18030",Enc:simplifiedchinese.GB18030,ASCIITrail:true,Collations:[]uint8{248,249}},
+	"big5":    {Name:"big5",Enc:traditionalchinese.Big5,ASCIITrail:true,Collations:[]uint8{1,84}},
+}
+
+var collations = make(map[uint8]*Charset)
+
+func init() {
+	for _,cs := range Charsets {
+		for _,id := range cs.Collations { collations[id] = cs }
+	}
+}
+
+/*
+Returns the character set of the collation id, UTF-8 if it is unknown.
+*/
+func charsetByCollation(id uint8) *Charset {
+	if cs,ok := collations[id]; ok { return cs }
+	return Charsets["utf8"]
+}
+
+/*
+The collation id, that is sent in the column definitions of text columns.
+*/
+func (cs *Charset) id() uint32 { return uint32(cs.Collations[0]) }
+
+/*
+The character set of column definitions: binary (63) for everything, that is
+not text, like MySQL does.
+*/
+func fieldCharset(t query.Type) uint32 {
+	if sqltypes.IsText(t) || t==sqltypes.TypeJSON { return collationUtf8 }
+	return collationBinary
+}
+
+/*
+Initializes the character sets from the handshake, on the first query. (The
+handler's NewConnection is called before the handshake.)
+*/
+func (cd *ClientData) initCharset(c *mysql.Conn) {
+	if cd.client!=nil { return }
+	cs := charsetByCollation(c.CharacterSet)
+	cd.client,cd.results = cs,cs
+}
+
+/*
+Converts the string literals of the query from the client's character set to
+UTF-8. The rest of the query (keywords, identifiers) is left as it is, unless
+the character set has ASCII trail bytes. Fails with ER_INVALID_CHARACTER_STRING,
+if a literal isn't valid in the client's character set.
+*/
+func (cd *ClientData) decode(query string) (string,error) {
+	if cd.client.Enc==nil { return query,nil }
+	if cd.client.ASCIITrail {
+		s,err := cd.client.Enc.NewDecoder().String(query)
+		if err!=nil || strings.ContainsRune(s,utf8.RuneError) {
+			return "",mysql.NewSQLError(ERInvalidCharacterString,"HY000","Invalid %s character string: '%X'",cd.client.Name,query)
+		}
+		return s,nil
+	}
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(query)}
+	var buf []byte
+	last := 0
+	for p.next(); p.typ!=0 && p.typ!=sqlparser.LEX_ERROR; p.next() {
+		if p.typ!=sqlparser.STRING { continue }
+		/* The literal and the whitespace before it. The tokenizer reads one character ahead. */
+		end := p.tkn.Position-1
+		raw := query[p.pos:end]
+		s,err := cd.client.Enc.NewDecoder().String(raw)
+		if err!=nil || strings.ContainsRune(s,utf8.RuneError) {
+			return "",mysql.NewSQLError(ERInvalidCharacterString,"HY000","Invalid %s character string: '%X'",cd.client.Name,strings.TrimSpace(raw))
+		}
+		buf = append(append(buf,query[last:p.pos]...),s...)
+		last = end
+	}
+	if buf==nil { return query,nil }
+	return string(append(buf,query[last:]...)),nil
+}
+
+/*
+Converts the text values of the results to the character set of the results.
+Characters, that can't be represented, become '?', like in MySQL.
+*/
+func (cd *ClientData) encodeResults(callback func(*sqltypes.Result) error) func(*sqltypes.Result) error {
+	cs := cd.results
+	return func(sr *sqltypes.Result) error {
+		for _,f := range sr.Fields {
+			if f.Charset!=collationBinary { f.Charset = cs.id() }
+		}
+		if cs.Enc==nil { return callback(sr) }
+		enc := encoding.ReplaceUnsupported(cs.Enc.NewEncoder())
+		for _,row := range sr.Rows {
+			for i,v := range row {
+				if v.IsNull() || i>=len(sr.Fields) || sr.Fields[i].Charset==collationBinary { continue }
+				if b,err := enc.Bytes(v.Raw()); err==nil { row[i] = sqltypes.MakeTrusted(v.Type(),b) }
+			}
+		}
+		return callback(sr)
+	}
+}
+
+var setNamesRx = regexp.MustCompile("^(?is)\\s*names\\s+['\"`]?(\\w+)['\"`]?(?:\\s+collate\\s+['\"`]?\\w+['\"`]?)?\\s*$")
+var setCharsetRx = regexp.MustCompile("^(?is)\\s*(?:character\\s+set|charset)\\s+['\"`]?(\\w+)['\"`]?\\s*$")
+var setCharsetVarRx = regexp.MustCompile("^(?is)\\s*(?:(?:session|local)\\s+|@@(?:session\\.|local\\.)?)?(character_set_client|character_set_results|character_set_connection|collation_connection)\\s*:?=\\s*['\"`]?(\\w+)['\"`]?\\s*$")
+
+func lookupCharset(name string) (*Charset,error) {
+	name = strings.ToLower(name)
+	if name=="default" { name = "utf8mb4" }
+	if cs,ok := Charsets[name]; ok { return cs,nil }
+	return nil,mysql.NewSQLError(ERUnknownCharacterSet,"42000","Unknown character set: '%s'",name)
+}
+
+/*
+Implements SET NAMES, SET CHARACTER SET and SET character_set_client/results.
+Returns false, if the SET statement has other assignments. The
+character_set_connection and collation_connection are accepted and ignored.
+*/
+func (g *Gateway) setCharset(c *mysql.Conn,query string) (bool,error) {
+	query = strings.TrimRight(strings.TrimSpace(query),";")
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(query),stmt:"SET"}
+	p.next()
+	if err := p.expect("set"); err!=nil { return false,nil }
+	items,err := splitTokens(query[p.pos:],',',"SET")
+	if err!=nil { return false,nil }
+
+	cd := c.ClientData.(*ClientData)
+	client,results := cd.client,cd.results
+	for _,item := range items {
+		if m := setNamesRx.FindStringSubmatch(item); m!=nil {
+			cs,err := lookupCharset(m[1])
+			if err!=nil { return true,err }
+			client,results = cs,cs
+		} else if m := setCharsetRx.FindStringSubmatch(item); m!=nil {
+			cs,err := lookupCharset(m[1])
+			if err!=nil { return true,err }
+			client,results = cs,cs
+		} else if m := setCharsetVarRx.FindStringSubmatch(item); m!=nil {
+			switch strings.ToLower(m[1]) {
+			case "character_set_client":
+				if client,err = lookupCharset(m[2]); err!=nil { return true,err }
+			case "character_set_results":
+				/* NULL means no conversion. */
+				if strings.EqualFold(m[2],"null") {
+					results = Charsets["utf8mb4"]
+				} else if results,err = lookupCharset(m[2]); err!=nil {
+					return true,err
+				}
+			}
+		} else {
+			return false,nil
+		}
+	}
+	cd.client,cd.results = client,results
+	return true,nil
+}
@@ -0,0 +1,70 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "testing"
+
+func TestDecode(t *testing.T) {
+	cases := []struct{
+		cs, query, want string
+		ok bool
+	}{
+		{"utf8","select 'caf\xc3\xa9'","select 'caf\xc3\xa9'",true},
+		{"latin1","select 'caf\xe9', `t\xe9`","select 'caf\xc3\xa9', `t\xe9`",true},
+		{"latin1","select 'it''s \xe9' from t where a = \"\xe9\"","select 'it''s \xc3\xa9' from t where a = \"\xc3\xa9\"",true},
+		{"latin1","select /* '\xe9' */ 1","select /* '\xe9' */ 1",true},
+		{"sjis","select '\x82\xa0'","select '\xe3\x81\x82'",true},
+		{"sjis","select '\x82'","",false},
+		/* The trail byte of 表 is a backslash. */
+		{"sjis","select '\x95\x5c' from `\x95\x5c`","select '\xe8\xa1\xa8' from `\xe8\xa1\xa8`",true},
+		{"big5","select '\xa5\x5c'","select '\xe5\x8a\x9f'",true},
+	}
+	for _,c := range cases {
+		cd := &ClientData{client:Charsets[c.cs]}
+		got,err := cd.decode(c.query)
+		if (err==nil)!=c.ok || got!=c.want {
+			t.Errorf("decode(%s, %q) = %q, %v; want %q, ok %v",c.cs,c.query,got,err,c.want,c.ok)
+		}
+	}
+}
+
+func TestSetCharset(t *testing.T) {
+	cases := []struct{
+		query, client, results string
+		handled, ok bool
+	}{
+		{"SET NAMES latin1","latin1","latin1",true,true},
+		{"set names 'utf8mb4' collate 'utf8mb4_bin';","utf8mb4","utf8mb4",true,true},
+		{"SET CHARACTER SET cp1251","cp1251","cp1251",true,true},
+		{"SET character_set_client = sjis, @@session.character_set_results = NULL","sjis","utf8mb4",true,true},
+		{"SET character_set_connection = latin1","utf8","utf8",true,true},
+		{"SET NAMES klingon","utf8","utf8",true,false},
+		{"SET NAMES latin1, autocommit = 1","utf8","utf8",false,true},
+		{"SET autocommit = 1","utf8","utf8",false,true},
+	}
+	g := new(Gateway)
+	for _,c := range cases {
+		cd := &ClientData{client:Charsets["utf8"],results:Charsets["utf8"]}
+		conn := &mysql.Conn{ClientData:cd}
+		handled,err := g.setCharset(conn,c.query)
+		if handled!=c.handled || (err==nil)!=c.ok || cd.client.Name!=c.client || cd.results.Name!=c.results {
+			t.Errorf("setCharset(%q) = %v, %v, client %s, results %s; want %v, ok %v, %s, %s",c.query,handled,err,cd.client.Name,cd.results.Name,c.handled,c.ok,c.client,c.results)
+		}
+	}
+}
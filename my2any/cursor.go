@@ -0,0 +1,96 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "context"
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "regexp"
+import "strconv"
+
+const (
+	ERTooBigSelect = 1104
+)
+
+const DefaultBatchBytes = 64*1024
+
+var limitRx = regexp.MustCompile(`(?i)\slimit\s+(\d+)(?:\s+offset\s+\d+)?\s*$`)
+
+/*
+Optional interface for SpecialFeatures, that fetch results with a server side
+cursor. DeclareCursor declares a cursor for the query in tx. fetch returns the
+next n rows, close closes the cursor.
+*/
+type Cursors interface{
+	DeclareCursor(tx *sql.Tx, query string) (fetch func(n int) (*sql.Rows,error), close func() error, err error)
+}
+
+/*
+Returns true, if the (translated) query has a LIMIT of at most n rows.
+*/
+func smallLimit(query string,n int) bool {
+	m := limitRx.FindStringSubmatch(query)
+	if m==nil { return false }
+	l,err := strconv.Atoi(m[1])
+	return err==nil && l<=n
+}
+
+/*
+Executes a SELECT with a cursor. Outside of a transaction, the cursor lives in a
+transaction of its own.
+*/
+func (g *Gateway) executeCursor(c *mysql.Conn,cur Cursors,db GenericDB,query string,callback func(*sqltypes.Result) error) error {
+	var tx *sql.Tx
+	var err error
+	switch v := db.(type) {
+	case *sql.Tx:
+		tx = v
+	case *sql.DB:
+		tx,err = v.Begin()
+	case connDB:
+		tx,err = v.BeginTx(context.Background(),nil)
+	default:
+		rs,err := db.Query(query)
+		if err!=nil { return err }
+		return g.streamRows(c,rs,callback)
+	}
+	if err!=nil { return err }
+	own := tx!=db
+
+	fetch,closeCursor,err := cur.DeclareCursor(tx,query)
+	if err==nil {
+		err = g.streamBatches(c,func(prev int) (*sql.Rows,error) {
+			if prev>=0 && prev<g.FetchSize { return nil,nil }
+			return fetch(g.FetchSize)
+		},callback)
+		/*
+		On errors as well: the cursor would stay open in the caller's transaction.
+		If the error has aborted the transaction, closing fails, which is ignored.
+		*/
+		if cerr := closeCursor(); err==nil { err = cerr }
+	}
+	if own {
+		if err==nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "fmt"
+import "reflect"
+import "testing"
+
+/* Cursors, that fail to fetch. */
+type failingCursors struct{}
+func (failingCursors) DeclareCursor(tx *sql.Tx, query string) (func(n int) (*sql.Rows,error),func() error,error) {
+	fetch := func(n int) (*sql.Rows,error) { return nil,fmt.Errorf("fetch failed") }
+	close := func() error {
+		_,err := tx.Exec("close")
+		return err
+	}
+	return fetch,close,nil
+}
+
+func TestExecuteCursorCloses(t *testing.T) {
+	db,err := sql.Open("my2any-log","")
+	if err!=nil { t.Fatal(err) }
+	defer db.Close()
+	g := &Gateway{DB:db,FetchSize:10}
+	c := &mysql.Conn{ClientData:new(ClientData)}
+	callback := func(*sqltypes.Result) error { return nil }
+
+	/* In a transaction of its own. */
+	testDriver.log = nil
+	if err = g.executeCursor(c,failingCursors{},db,"select 1",callback); err==nil { t.Errorf("no error") }
+	if want := []string{"begin","close","rollback"}; !reflect.DeepEqual(testDriver.log,want) {
+		t.Errorf("statements %q, want %q",testDriver.log,want)
+	}
+
+	/* In the caller's transaction. */
+	testDriver.log = nil
+	tx,err := db.Begin()
+	if err!=nil { t.Fatal(err) }
+	defer tx.Rollback()
+	if err = g.executeCursor(c,failingCursors{},tx,"select 1",callback); err==nil { t.Errorf("no error") }
+	if want := []string{"begin","close"}; !reflect.DeepEqual(testDriver.log,want) {
+		t.Errorf("statements %q, want %q",testDriver.log,want)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strconv"
+import "strings"
+
+const (
+	ERTableAccessDenied = 1142
+	SSTableAccessDenied = "42000"
+)
+
+/*
+Checks statements after parsing, before they are translated.
+*/
+type Firewall interface{
+	/*
+	Returns an error, if the statement is denied. The statement may be modified
+	(like capping the LIMIT), in which case modified is true.
+	*/
+	Check(user, schema string, st sqlparser.Statement) (modified bool, err error)
+}
+
+/*
+A firewall rule. A rule applies, if User and Schema match ("" and "*" match any).
+
+Statement kinds are "select", "insert", "replace", "update", "delete", "ddl",
+"set" and "other". Tables are given as "name" or "schema.name".
+*/
+type Rule struct{
+	User   string `json:"user"`
+	Schema string `json:"schema"`
+	
+	DenyStatements []string `json:"deny_statements"`
+	DenyDeleteWithoutWhere bool `json:"deny_delete_without_where"`
+	DenyUpdateWithoutWhere bool `json:"deny_update_without_where"`
+	
+	/* If not empty, only these tables may be accessed. */
+	AllowTables []string `json:"allow_tables"`
+	DenyTables  []string `json:"deny_tables"`
+	
+	/* Tables, that can't be selected, updated or deleted from without a WHERE clause. */
+	RequireWhere []string `json:"require_where"`
+	
+	/* If >0, caps the LIMIT of SELECT statements (and adds one, if missing). */
+	MaxLimit int `json:"max_limit"`
+}
+
+func matchName(pattern, name string) bool {
+	return pattern=="" || pattern=="*" || pattern==name
+}
+
+func (r *Rule) applies(user, schema string) bool {
+	return matchName(r.User,user) && matchName(r.Schema,schema)
+}
+
+/*
+A Firewall, that applies all matching rules.
+*/
+type Policy struct{
+	Rules []*Rule `json:"rules"`
+}
+
+func stmtKind(st sqlparser.Statement) string {
+	switch v := st.(type) {
+	case *sqlparser.Select,*sqlparser.Union,*sqlparser.ParenSelect: return "select"
+	case *sqlparser.Insert:
+		if v.Action==sqlparser.ReplaceStr { return "replace" }
+		return "insert"
+	case *sqlparser.Update: return "update"
+	case *sqlparser.Delete: return "delete"
+	case *sqlparser.DDL: return "ddl"
+	case *sqlparser.Set: return "set"
+	}
+	return "other"
+}
+
+/*
+Returns the tables, the statement refers to, as "schema.name".
+*/
+func stmtTables(st sqlparser.Statement, schema string) []string {
+	var tabs []string
+	add := func(tn sqlparser.TableName) {
+		if tn.Name.IsEmpty() { return }
+		ns := schema
+		if !tn.Qualifier.IsEmpty() { ns = tn.Qualifier.String() }
+		tabs = append(tabs,ns+"."+tn.Name.String())
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch v := node.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if tn,ok := v.Expr.(sqlparser.TableName); ok { add(tn) }
+		case *sqlparser.Insert: add(v.Table)
+		case *sqlparser.DDL:
+			add(v.Table)
+			add(v.NewName)
+		}
+		return true,nil
+	},st)
+	return tabs
+}
+
+func inList(list []string, table, schema string) bool {
+	for _,t := range list {
+		if !strings.Contains(t,".") { t = schema+"."+t }
+		if t==table { return true }
+	}
+	return false
+}
+
+func denied(kind, user, reason string) error {
+	return mysql.NewSQLError(ERTableAccessDenied,SSTableAccessDenied,"%s command denied to user '%s' %s",strings.ToUpper(kind),user,reason)
+}
+
+func hasWhere(st sqlparser.Statement) bool {
+	switch v := st.(type) {
+	case *sqlparser.Select: return v.Where!=nil
+	case *sqlparser.Update: return v.Where!=nil
+	case *sqlparser.Delete: return v.Where!=nil
+	}
+	return true
+}
+
+/*
+Returns the tables of the FROM clause, without those in subqueries.
+*/
+func fromTables(tes sqlparser.TableExprs) (tabs []sqlparser.TableName) {
+	for _,te := range tes {
+		switch v := te.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if tn,ok := v.Expr.(sqlparser.TableName); ok { tabs = append(tabs,tn) }
+		case *sqlparser.ParenTableExpr:
+			tabs = append(tabs,fromTables(v.Exprs)...)
+		case *sqlparser.JoinTableExpr:
+			tabs = append(tabs,fromTables(sqlparser.TableExprs{v.LeftExpr,v.RightExpr})...)
+		}
+	}
+	return
+}
+
+/*
+Returns the first table of the list, that is read by a SELECT, UPDATE or DELETE
+without a WHERE clause, anywhere in the statement (UNION, subqueries, ...).
+*/
+func withoutWhere(st sqlparser.Statement, schema string, list []string) (table string) {
+	check := func(where *sqlparser.Where, tes sqlparser.TableExprs) {
+		if where!=nil || table!="" { return }
+		for _,tn := range fromTables(tes) {
+			if tn.Name.IsEmpty() { continue }
+			ns := schema
+			if !tn.Qualifier.IsEmpty() { ns = tn.Qualifier.String() }
+			if t := ns+"."+tn.Name.String(); inList(list,t,schema) {
+				table = t
+				return
+			}
+		}
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch v := node.(type) {
+		case *sqlparser.Select: check(v.Where,v.From)
+		case *sqlparser.Update: check(v.Where,v.TableExprs)
+		case *sqlparser.Delete: check(v.Where,v.TableExprs)
+		}
+		return table=="",nil
+	},st)
+	return
+}
+
+func capLimit(st sqlparser.Statement, max int) bool {
+	var lp **sqlparser.Limit
+	switch v := st.(type) {
+	case *sqlparser.Select: lp = &v.Limit
+	case *sqlparser.Union: lp = &v.Limit
+	default: return false
+	}
+	maxv := sqlparser.NewIntVal([]byte(strconv.Itoa(max)))
+	if *lp==nil {
+		*lp = &sqlparser.Limit{Rowcount:maxv}
+		return true
+	}
+	rc,ok := (*lp).Rowcount.(*sqlparser.SQLVal)
+	if !ok || rc.Type!=sqlparser.IntVal { return false }
+	if n,err := strconv.Atoi(string(rc.Val)); err==nil && n<=max { return false }
+	(*lp).Rowcount = maxv
+	return true
+}
+
+func (p *Policy) Check(user, schema string, st sqlparser.Statement) (modified bool, err error) {
+	kind := stmtKind(st)
+	var tabs []string
+	for _,r := range p.Rules {
+		if !r.applies(user,schema) { continue }
+		for _,k := range r.DenyStatements {
+			if strings.ToLower(k)==kind { return modified,denied(kind,user,"by policy") }
+		}
+		if (kind=="delete" && r.DenyDeleteWithoutWhere) || (kind=="update" && r.DenyUpdateWithoutWhere) {
+			if !hasWhere(st) { return modified,denied(kind,user,"without a WHERE clause") }
+		}
+		if tabs==nil { tabs = stmtTables(st,schema) }
+		for _,t := range tabs {
+			if len(r.AllowTables)>0 && !inList(r.AllowTables,t,schema) || inList(r.DenyTables,t,schema) {
+				return modified,denied(kind,user,"for table '"+t+"'")
+			}
+		}
+		if len(r.RequireWhere)>0 {
+			if t := withoutWhere(st,schema,r.RequireWhere); t!="" {
+				return modified,denied(kind,user,"for table '"+t+"' without a WHERE clause")
+			}
+		}
+		if r.MaxLimit>0 && capLimit(st,r.MaxLimit) { modified = true }
+	}
+	return
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "testing"
+
+func TestPolicyCheck(t *testing.T) {
+	p := &Policy{Rules: []*Rule{
+		{User:"*",RequireWhere:[]string{"big"},DenyDeleteWithoutWhere:true},
+		{User:"report",DenyStatements:[]string{"update"},DenyTables:[]string{"s.secrets"},MaxLimit:10},
+	}}
+	cases := []struct{
+		user, query string
+		denied, modified bool
+		limit string
+	}{
+		{"app","select * from big",true,false,""},
+		{"app","select * from big where id = 1",false,false,""},
+		{"app","select * from small union select * from big",true,false,""},
+		{"app","select * from small where id in (select id from big)",true,false,""},
+		{"app","select * from (select * from big) as t where t.id = 1",true,false,""},
+		{"app","select * from small join big on small.id = big.id",true,false,""},
+		{"app","select * from other.big",false,false,""},
+		{"app","update s.big set x = 1 where id = 2",false,false,""},
+		{"app","delete from small",true,false,""},
+		{"report","update small set x = 1 where id = 2",true,false,""},
+		{"report","select * from secrets where id = 1",true,false,""},
+		{"report","select * from small",false,true," limit 10"},
+		{"report","select * from small limit 5",false,false," limit 5"},
+		{"report","select * from small limit 50",false,true," limit 10"},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse(c.query)
+		if err!=nil { t.Fatalf("%s: %v",c.query,err) }
+		modified,err := p.Check(c.user,"s",st)
+		if (err!=nil)!=c.denied || modified!=c.modified {
+			t.Errorf("Check(%q, %q) = %v, %v; want denied %v, modified %v",c.user,c.query,modified,err,c.denied,c.modified)
+			continue
+		}
+		if sel,ok := st.(*sqlparser.Select); ok && !c.denied && sqlparser.String(sel.Limit)!=c.limit {
+			t.Errorf("Check(%q, %q): limit %q, want %q",c.user,c.query,sqlparser.String(sel.Limit),c.limit)
+		}
+	}
+}
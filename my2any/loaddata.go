@@ -0,0 +1,464 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "github.com/a-mail-group/yoursql/querylog"
+import "bufio"
+import "bytes"
+import "io"
+import "os"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+import "fmt"
+
+var loadRx = regexp.MustCompile(`^(?i)\s*load\s+data\s`)
+
+const (
+	ERFileNotFound = 1017
+	ERNotAllowedCommand = 1148
+)
+
+/*
+Optional interface for SpecialFeatures, that support LOAD DATA INFILE.
+
+BulkLoad inserts the rows returned by next (until it returns io.EOF) into the
+table. Values are strings or nil (NULL). If columns is empty, the values are
+given for all columns of the table, in order.
+*/
+type BulkLoader interface{
+	BulkLoad(tx *sql.Tx, table sqlparser.TableName, columns []string, next func() ([]interface{},error)) (int64,error)
+}
+
+/*
+Implemented by the net.Addr, that mysql.Conn.RemoteAddr() returns, if the listener
+supports LOAD DATA LOCAL INFILE (see server.Config.LocalInfile): vitess' mysql.Conn
+can't send the file request in the middle of a command.
+*/
+type LocalInfiler interface{
+	/* Requests the file from the client. It must be closed, before the command ends. */
+	RequestFile(name string) (io.ReadCloser,error)
+	
+	/* Sets the info string and the warning count of the OK packet, that ends the command. */
+	SetInfo(info string,warnings uint16)
+}
+
+/*
+The options of a LOAD DATA statement.
+*/
+type loadData struct{
+	file    string
+	local   bool
+	table   sqlparser.TableName
+	columns []string
+	
+	fieldsTerm  string
+	enclosed    string
+	optionally  bool
+	escaped     string
+	linesStart  string
+	linesTerm   string
+	ignoreLines int
+}
+
+type loadParser struct{
+	tkn *sqlparser.Tokenizer
+	typ int
+	val string
+	
+	/* The offset of the current token (including the whitespace before it). */
+	pos int
+	
+	/* The statement, for error messages (default: LOAD DATA). */
+	stmt string
+}
+func (p *loadParser) syntaxError(expected string) error {
+	stmt := p.stmt
+	if stmt=="" { stmt = "LOAD DATA" }
+	return fmt.Errorf("syntax error in %s near '%s', expected %s",stmt,p.val,expected)
+}
+func (p *loadParser) next() {
+	/* The tokenizer reads one character ahead. */
+	p.pos = p.tkn.Position-1
+	if p.pos<0 { p.pos = 0 }
+	typ,val := p.tkn.Scan()
+	p.typ,p.val = typ,string(val)
+}
+func (p *loadParser) word() string {
+	if p.typ==sqlparser.STRING { return "" }
+	return strings.ToLower(p.val)
+}
+func (p *loadParser) accept(words ...string) bool {
+	for _,w := range words {
+		if p.word()==w { p.next(); return true }
+	}
+	return false
+}
+func (p *loadParser) expect(words ...string) error {
+	if p.accept(words...) { return nil }
+	return p.syntaxError(strings.ToUpper(strings.Join(words,"|")))
+}
+func (p *loadParser) str() (string,error) {
+	if p.typ!=sqlparser.STRING { return "",p.syntaxError("a string") }
+	s := p.val
+	p.next()
+	return s,nil
+}
+func (p *loadParser) ident() (string,error) {
+	if p.typ==sqlparser.STRING || p.typ==0 || p.val=="" { return "",p.syntaxError("an identifier") }
+	s := p.val
+	p.next()
+	return s,nil
+}
+
+/*
+Splits the text at the separator token (outside of strings, comments and parentheses).
+*/
+func splitTokens(text string,sep int,stmt string) (pieces []string,err error) {
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(text),stmt:stmt}
+	start,depth := 0,0
+	for p.next(); p.typ!=0; p.next() {
+		switch p.typ {
+		case sqlparser.LEX_ERROR: return nil,p.syntaxError("a statement")
+		case '(': depth++
+		case ')': depth--
+		case sep:
+			if depth>0 { break }
+			/* p.pos may point to the whitespace before the separator. */
+			end := p.pos+strings.IndexByte(text[p.pos:],byte(sep))
+			pieces = append(pieces,text[start:end])
+			start = end+1
+		}
+	}
+	return append(pieces,text[start:]),nil
+}
+
+/*
+Parses [CHARACTER SET cs] [{FIELDS | COLUMNS} [TERMINATED BY 's'] [[OPTIONALLY] ENCLOSED BY 'c']
+[ESCAPED BY 'c']] [LINES [STARTING BY 's'] [TERMINATED BY 's']], as used by LOAD DATA
+and SELECT ... INTO OUTFILE.
+*/
+func (p *loadParser) format(ld *loadData) (err error) {
+	if p.accept("character","charset") {
+		p.accept("set")
+		if _,err = p.ident(); err!=nil { return }
+	}
+	if p.accept("fields","columns") {
+		for {
+			switch {
+			case p.accept("terminated"):
+				if err = p.expect("by"); err!=nil { return }
+				if ld.fieldsTerm,err = p.str(); err!=nil { return }
+			case p.word()=="optionally" || p.word()=="enclosed":
+				ld.optionally = p.accept("optionally")
+				if err = p.expect("enclosed"); err!=nil { return }
+				if err = p.expect("by"); err!=nil { return }
+				if ld.enclosed,err = p.str(); err!=nil { return }
+			case p.accept("escaped"):
+				if err = p.expect("by"); err!=nil { return }
+				if ld.escaped,err = p.str(); err!=nil { return }
+			default:
+				goto lines
+			}
+		}
+	}
+	lines:
+	if p.accept("lines") {
+		for {
+			switch {
+			case p.accept("starting"):
+				if err = p.expect("by"); err!=nil { return }
+				if ld.linesStart,err = p.str(); err!=nil { return }
+			case p.accept("terminated"):
+				if err = p.expect("by"); err!=nil { return }
+				if ld.linesTerm,err = p.str(); err!=nil { return }
+			default:
+				return
+			}
+		}
+	}
+	return
+}
+
+/*
+Parses LOAD DATA [LOW_PRIORITY | CONCURRENT] [LOCAL] INFILE 'file' [REPLACE | IGNORE]
+INTO TABLE tbl [CHARACTER SET cs] [{FIELDS | COLUMNS} [TERMINATED BY 's']
+[[OPTIONALLY] ENCLOSED BY 'c'] [ESCAPED BY 'c']] [LINES [STARTING BY 's'] [TERMINATED BY 's']]
+[IGNORE n {LINES | ROWS}] [(col, ...)]
+*/
+func parseLoadData(query string) (ld *loadData,err error) {
+	ld = &loadData{fieldsTerm:"\t",escaped:"\\",linesTerm:"\n"}
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(query)}
+	p.next()
+	if err = p.expect("load"); err!=nil { return }
+	if err = p.expect("data"); err!=nil { return }
+	p.accept("low_priority","concurrent")
+	ld.local = p.accept("local")
+	if err = p.expect("infile"); err!=nil { return }
+	if ld.file,err = p.str(); err!=nil { return }
+	if w := p.word(); w=="replace" || w=="ignore" {
+		return nil,notSupported("LOAD DATA ... "+strings.ToUpper(w))
+	}
+	if err = p.expect("into"); err!=nil { return }
+	if err = p.expect("table"); err!=nil { return }
+	var name string
+	if name,err = p.ident(); err!=nil { return }
+	ld.table.Name = sqlparser.NewTableIdent(name)
+	if p.typ=='.' {
+		p.next()
+		ld.table.Qualifier = ld.table.Name
+		if name,err = p.ident(); err!=nil { return }
+		ld.table.Name = sqlparser.NewTableIdent(name)
+	}
+	if err = p.format(ld); err!=nil { return nil,err }
+	if p.accept("ignore") {
+		if p.typ!=sqlparser.INTEGRAL { return nil,p.syntaxError("a number") }
+		ld.ignoreLines,_ = strconv.Atoi(p.val)
+		p.next()
+		if err = p.expect("lines","rows"); err!=nil { return }
+	}
+	if p.typ=='(' {
+		p.next()
+		for p.typ!=')' {
+			if name,err = p.ident(); err!=nil { return }
+			ld.columns = append(ld.columns,name)
+			if p.typ==',' { p.next() }
+		}
+		p.next()
+	}
+	if p.typ==';' { p.next() }
+	if p.typ!=0 {
+		return nil,fmt.Errorf("LOAD DATA: unsupported clause near '%s'",p.val)
+	}
+	if len(ld.enclosed)>1 || len(ld.escaped)>1 {
+		return nil,fmt.Errorf("LOAD DATA: ENCLOSED BY and ESCAPED BY must be a single character")
+	}
+	if ld.fieldsTerm=="" || ld.linesTerm=="" {
+		return nil,fmt.Errorf("LOAD DATA: empty FIELDS or LINES TERMINATED BY are not supported")
+	}
+	return
+}
+
+/*
+Splits the file into records, following MySQL's rules for FIELDS and LINES.
+*/
+type infileScanner struct{
+	r  *bufio.Reader
+	ld *loadData
+}
+
+func (s *infileScanner) at(term string) bool {
+	b,_ := s.r.Peek(len(term))
+	return bytes.Equal(b,[]byte(term))
+}
+
+func unescape(ch byte) byte {
+	switch ch {
+	case '0': return 0
+	case 'b': return '\b'
+	case 'n': return '\n'
+	case 'r': return '\r'
+	case 't': return '\t'
+	case 'Z': return 26
+	}
+	return ch
+}
+
+/*
+Reads a field. Returns the field and whether it ended the line. A nil field is NULL.
+*/
+func (s *infileScanner) field() (f *string,eol bool,err error) {
+	ld := s.ld
+	var buf []byte
+	enclosed := false
+	quoted := false
+	escapedN := false
+	if ld.enclosed!="" && s.at(ld.enclosed) {
+		s.r.ReadByte()
+		enclosed = true
+		quoted = true
+	}
+	for {
+		if !enclosed {
+			if s.at(ld.fieldsTerm) {
+				s.r.Discard(len(ld.fieldsTerm))
+				break
+			}
+			if s.at(ld.linesTerm) {
+				s.r.Discard(len(ld.linesTerm))
+				eol = true
+				break
+			}
+		}
+		ch,e := s.r.ReadByte()
+		if e==io.EOF {
+			if enclosed {
+				/* Unterminated enclosure, take it literally. */
+				buf = append([]byte(ld.enclosed),buf...)
+			}
+			eol = true
+			if len(buf)==0 && !quoted && !escapedN { err = io.EOF }
+			break
+		}
+		if e!=nil { return nil,false,e }
+		switch {
+		case ld.escaped!="" && ch==ld.escaped[0]:
+			nch,e := s.r.ReadByte()
+			if e!=nil { buf = append(buf,ch); continue }
+			if nch=='N' && !enclosed && len(buf)==0 {
+				escapedN = true
+				continue
+			}
+			buf = append(buf,unescape(nch))
+		case enclosed && ch==ld.enclosed[0]:
+			if s.at(ld.enclosed) {
+				s.r.ReadByte()
+				buf = append(buf,ch)
+				continue
+			}
+			/* The enclosure ends, when followed by a terminator. */
+			if s.at(ld.fieldsTerm) || s.at(ld.linesTerm) || s.atEOF() {
+				enclosed = false
+				continue
+			}
+			buf = append(buf,ch)
+		default:
+			buf = append(buf,ch)
+		}
+	}
+	if escapedN && len(buf)==0 { return nil,eol,err }
+	str := string(buf)
+	/* With ENCLOSED BY, an unenclosed NULL is NULL. */
+	if ld.enclosed!="" && !quoted && str=="NULL" { return nil,eol,err }
+	return &str,eol,err
+}
+func (s *infileScanner) atEOF() bool {
+	_,err := s.r.Peek(1)
+	return err==io.EOF
+}
+
+/*
+Returns the next record, or io.EOF.
+*/
+func (s *infileScanner) record() ([]*string,error) {
+	if s.ld.linesStart!="" {
+		for !s.at(s.ld.linesStart) {
+			if _,err := s.r.ReadByte(); err!=nil { return nil,err }
+		}
+		s.r.Discard(len(s.ld.linesStart))
+	}
+	var rec []*string
+	for {
+		f,eol,err := s.field()
+		if err==io.EOF && len(rec)==0 { return nil,io.EOF }
+		if err!=nil && err!=io.EOF { return nil,err }
+		rec = append(rec,f)
+		if eol { return rec,nil }
+	}
+}
+
+/*
+Implements LOAD DATA [LOCAL] INFILE: The file is requested from the client (LOCAL)
+or read from the import directory (Gateway.ImportDir) of the gateway host, parsed
+according to the FIELDS and LINES options, and passed to the BulkLoader.
+*/
+func (g *Gateway) loadData(c *mysql.Conn,query string,callback func(*sqltypes.Result) error,le *querylog.Entry) error {
+	bl,ok := g.SF.(BulkLoader)
+	if !ok { return fmt.Errorf("LOAD DATA is not supported by this backend") }
+	ld,err := parseLoadData(query)
+	if err!=nil { return err }
+	lf,_ := c.RemoteAddr().(LocalInfiler)
+	if ld.local && lf==nil {
+		return mysql.NewSQLError(ERNotAllowedCommand,"42000","LOAD DATA LOCAL INFILE is not enabled on this listener")
+	}
+	if ld.table.Qualifier.IsEmpty() && c.SchemaName!="" {
+		ld.table.Qualifier = sqlparser.NewTableIdent(c.SchemaName)
+	}
+	if g.Firewall!=nil {
+		if _,err = g.Firewall.Check(c.User,c.SchemaName,&sqlparser.Insert{Action:sqlparser.InsertStr,Table:ld.table}); err!=nil { return err }
+	}
+	
+	var f io.ReadCloser
+	if ld.local {
+		if f,err = lf.RequestFile(ld.file); err!=nil { return err }
+	} else {
+		path,err := g.importPath(ld.file)
+		if err!=nil { return err }
+		f,err = os.Open(path)
+		if os.IsNotExist(err) {
+			return mysql.NewSQLError(ERFileNotFound,"HY000","Can't find file: '%s' (errno: 2)",ld.file)
+		}
+		if err!=nil { return err }
+	}
+	/* For LOCAL, this reads the rest of the file, the client sends it anyway. */
+	defer f.Close()
+	
+	s := &infileScanner{r:bufio.NewReaderSize(f,65536),ld:ld}
+	for i := 0; i<ld.ignoreLines; i++ {
+		if _,err = s.record(); err!=nil { break }
+	}
+	ncols := len(ld.columns)
+	var warnings uint64
+	next := func() ([]interface{},error) {
+		rec,err := s.record()
+		if err!=nil { return nil,err }
+		if ncols==0 { ncols = len(rec) }
+		/* Like MySQL, missing fields are NULL, extra fields are dropped (with a warning). */
+		if len(rec)!=ncols { warnings++ }
+		row := make([]interface{},ncols)
+		for i := range row {
+			if i<len(rec) && rec[i]!=nil { row[i] = *rec[i] }
+		}
+		return row,nil
+	}
+	
+	cd := c.ClientData.(*ClientData)
+	tx := cd.Tx
+	if tx==nil {
+		if tx,err = g.begin(cd); err!=nil { return err }
+	}
+	n,err := bl.BulkLoad(tx,ld.table,ld.columns,next)
+	if cd.Tx==nil {
+		if err==nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err!=nil { return err }
+	cd.LastWrite = time.Now()
+	if g.Cache!=nil { g.Cache.InvalidateTable(ld.table.Qualifier.String(),ld.table.Name.String()) }
+	
+	/*
+	The warnings are records with too few or too many fields. Records are only
+	skipped with IGNORE, which isn't supported. The info string of the OK packet
+	can only be set through the listener.
+	*/
+	if le!=nil { le.Warnings += warnings }
+	if lf!=nil {
+		count := uint16(0xffff)
+		if warnings<0xffff { count = uint16(warnings) }
+		lf.SetInfo(fmt.Sprintf("Records: %d  Deleted: 0  Skipped: 0  Warnings: %d",n,warnings),count)
+	}
+	return callback(&sqltypes.Result{RowsAffected:uint64(n)})
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "bufio"
+import "io"
+import "reflect"
+import "strings"
+import "testing"
+
+func TestParseLoadData(t *testing.T) {
+	cases := []struct{
+		query string
+		want  *loadData
+	}{
+		{"LOAD DATA INFILE 'a.txt' INTO TABLE t",
+			&loadData{file:"a.txt",table:sqlparser.TableName{Name:sqlparser.NewTableIdent("t")},fieldsTerm:"\t",escaped:"\\",linesTerm:"\n"}},
+		{"load data local infile '/tmp/a.csv' into table s.t character set utf8 fields terminated by ',' optionally enclosed by '\"' escaped by '' lines starting by '>' terminated by '\\r\\n' ignore 1 lines (a, b);",
+			&loadData{file:"/tmp/a.csv",local:true,table:sqlparser.TableName{Qualifier:sqlparser.NewTableIdent("s"),Name:sqlparser.NewTableIdent("t")},columns:[]string{"a","b"},
+				fieldsTerm:",",enclosed:"\"",optionally:true,linesStart:">",linesTerm:"\r\n",ignoreLines:1}},
+		{"LOAD DATA INFILE 'a' INTO TABLE t COLUMNS ENCLOSED BY '\\''",
+			&loadData{file:"a",table:sqlparser.TableName{Name:sqlparser.NewTableIdent("t")},fieldsTerm:"\t",enclosed:"'",escaped:"\\",linesTerm:"\n"}},
+		{"LOAD DATA INFILE a INTO TABLE t",nil},
+		{"LOAD DATA INFILE 'a' TABLE t",nil},
+		{"LOAD DATA INFILE 'a' INTO TABLE t SET x = 1",nil},
+		{"LOAD DATA INFILE 'a' INTO TABLE t FIELDS ENCLOSED BY '\"\"'",nil},
+		{"LOAD DATA INFILE 'a' INTO TABLE t LINES TERMINATED BY ''",nil},
+		{"LOAD DATA INFILE 'a' INTO TABLE t IGNORE x LINES",nil},
+		{"LOAD DATA INFILE 'a' REPLACE INTO TABLE t",nil},
+		{"LOAD DATA INFILE 'a' IGNORE INTO TABLE t",nil},
+	}
+	for _,c := range cases {
+		ld,err := parseLoadData(c.query)
+		if c.want==nil {
+			if err==nil { t.Errorf("parseLoadData(%q): no error",c.query) }
+			continue
+		}
+		if err!=nil {
+			t.Errorf("parseLoadData(%q): %v",c.query,err)
+			continue
+		}
+		if !reflect.DeepEqual(ld,c.want) {
+			t.Errorf("parseLoadData(%q) = %+v, want %+v",c.query,ld,c.want)
+		}
+	}
+}
+
+func scanAll(ld *loadData,text string) (recs [][]string,err error) {
+	s := &infileScanner{r:bufio.NewReader(strings.NewReader(text)),ld:ld}
+	for {
+		rec,err := s.record()
+		if err==io.EOF { return recs,nil }
+		if err!=nil { return nil,err }
+		var r []string
+		for _,f := range rec {
+			if f==nil { r = append(r,"<null>") } else { r = append(r,*f) }
+		}
+		recs = append(recs,r)
+	}
+}
+
+func TestInfileScanner(t *testing.T) {
+	tab := &loadData{fieldsTerm:"\t",escaped:"\\",linesTerm:"\n"}
+	csv := &loadData{fieldsTerm:",",enclosed:"\"",optionally:true,escaped:"\\",linesTerm:"\r\n"}
+	pre := &loadData{fieldsTerm:",",linesStart:">",linesTerm:"\n"}
+	cases := []struct{
+		ld   *loadData
+		text string
+		want [][]string
+	}{
+		{tab,"a\tb\nc\td\n",[][]string{{"a","b"},{"c","d"}}},
+		{tab,"a\tb\nc",[][]string{{"a","b"},{"c"}}},
+		{tab,"\\N\t\\n\\t\\\\\t\n",[][]string{{"<null>","\n\t\\",""}}},
+		{tab,"NULL\tx\\Ny\n",[][]string{{"NULL","xNy"}}},
+		{tab,"",nil},
+		{csv,"1,\"a,b\",\"say \"\"hi\"\"\"\r\n2,NULL,\"NULL\"\r\n",[][]string{{"1","a,b","say \"hi\""},{"2","<null>","NULL"}}},
+		{csv,"\"a\"b\",c\r\n",[][]string{{"a\"b","c"}}},
+		{csv,"\"open,x\r\n",[][]string{{"\"open,x\r\n"}}},
+		{pre,"junk>a,b\n>c\nmore>d,e\n",[][]string{{"a","b"},{"c"},{"d","e"}}},
+	}
+	for _,c := range cases {
+		got,err := scanAll(c.ld,c.text)
+		if err!=nil {
+			t.Errorf("scan(%q): %v",c.text,err)
+			continue
+		}
+		if !reflect.DeepEqual(got,c.want) {
+			t.Errorf("scan(%q) = %q, want %q",c.text,got,c.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "context"
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "hash/fnv"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+import "fmt"
+
+const (
+	ERNotSupportedYet = 1235
+)
+
+var lockFuncRx = regexp.MustCompile(`(?i)\b(?:get_lock|release_lock|release_all_locks|is_free_lock)\s*\(`)
+var lockTablesRx = regexp.MustCompile(`^(?i)\s*lock\s+tables?\s`)
+var unlockTablesRx = regexp.MustCompile(`^(?i)\s*unlock\s+tables?\s*;?\s*$`)
+
+/*
+The interval, in which GET_LOCK retries to acquire a lock, until it times out.
+*/
+var LockPollInterval = 100*time.Millisecond
+
+/*
+Optional interface for SpecialFeatures, that implement MySQL's named locks
+(GET_LOCK and friends) and LOCK TABLES.
+
+The named locks are session level locks, the db is a connection, that is pinned
+to the client, as long as it holds any of them. The key is a hash of the lock
+name. TryLock and Unlock must count, like MySQL does: a lock acquired twice must
+be released twice. LockTables locks the (qualified) tables until the end of tx.
+The statements under LOCK TABLES run in that transaction, each one in a savepoint
+(SAVEPOINT, ROLLBACK TO SAVEPOINT, RELEASE SAVEPOINT).
+*/
+type Locker interface{
+	TryLock(db GenericDB, key int64) (bool,error)
+	Unlock(db GenericDB, key int64) (bool,error)
+	UnlockAll(db GenericDB) error
+	LockTables(tx *sql.Tx, tables []TableLock) error
+}
+
+type TableLock struct{
+	Table sqlparser.TableName
+	Write bool
+}
+
+/*
+A *sql.Conn as GenericDB.
+*/
+type connDB struct{
+	*sql.Conn
+}
+func (c connDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(),query,args...)
+}
+func (c connDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.PrepareContext(context.Background(),query)
+}
+func (c connDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(),query,args...)
+}
+func (c connDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(),query,args...)
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+/*
+Starts a transaction, on the pinned connection, if there is one.
+*/
+func (g *Gateway) begin(cd *ClientData) (*sql.Tx,error) {
+	if cd.Conn!=nil { return cd.Conn.BeginTx(context.Background(),nil) }
+	return g.DB.Begin()
+}
+
+func (g *Gateway) pin(cd *ClientData) (GenericDB,error) {
+	if cd.Conn==nil {
+		conn,err := g.DB.Conn(context.Background())
+		if err!=nil { return nil,err }
+		cd.Conn = conn
+	}
+	return connDB{cd.Conn},nil
+}
+
+/*
+Returns the pinned connection to the pool, once it isn't needed anymore.
+*/
+func (g *Gateway) unpin(cd *ClientData) {
+	if cd.Conn==nil || len(cd.locks)>0 || cd.Tx!=nil { return }
+	cd.Conn.Close()
+	cd.Conn = nil
+}
+
+/*
+Releases the locks of a disconnected client.
+*/
+func (g *Gateway) releaseLocks(cd *ClientData) {
+	if cd.Conn==nil || len(cd.locks)==0 { return }
+	if l,ok := g.SF.(Locker); ok { l.UnlockAll(connDB{cd.Conn}) }
+	cd.locks = nil
+}
+
+func lockArg(e sqlparser.Expr) (*sqlparser.SQLVal,error) {
+	if v,ok := e.(*sqlparser.SQLVal); ok { return v,nil }
+	if _,ok := e.(*sqlparser.NullVal); ok { return nil,nil }
+	return nil,mysql.NewSQLError(ERNotSupportedYet,"42000","This version of MySQL doesn't yet support 'lock functions with non-literal arguments'")
+}
+
+/*
+Implements SELECT GET_LOCK(name, timeout), RELEASE_LOCK(name), IS_FREE_LOCK(name)
+and RELEASE_ALL_LOCKS() (without FROM, or FROM DUAL). Each select expression must
+be one of these functions.
+*/
+func (g *Gateway) lockFunc(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	notsupp := mysql.NewSQLError(ERNotSupportedYet,"42000","This version of MySQL doesn't yet support 'lock functions in this context'")
+	l,ok := g.SF.(Locker)
+	if !ok { return fmt.Errorf("GET_LOCK() is not supported by this backend") }
+	st,err := decodeSql(query)
+	if err!=nil { return err }
+	sel,ok := st.(*sqlparser.Select)
+	if !ok || sel.Where!=nil || sel.GroupBy!=nil || sel.Having!=nil || len(sel.From)>1 { return notsupp }
+	if len(sel.From)==1 && sqlparser.String(sel.From[0])!="dual" { return notsupp }
+
+	cd := c.ClientData.(*ClientData)
+	db,err := g.pin(cd)
+	if err!=nil { return err }
+	defer g.unpin(cd)
+	if cd.locks==nil { cd.locks = make(map[int64]int) }
+
+	sch := make(sqlv.Schema,len(sel.SelectExprs))
+	row := make([]interface{},len(sel.SelectExprs))
+	for i,se := range sel.SelectExprs {
+		ae,ok := se.(*sqlparser.AliasedExpr)
+		if !ok { return notsupp }
+		fe,ok := ae.Expr.(*sqlparser.FuncExpr)
+		if !ok { return notsupp }
+		name := ae.As.String()
+		if name=="" { name = sqlparser.String(ae.Expr) }
+		sch[i] = &sqlv.Column{Name:name,Type:sqlv.Int64}
+
+		var args []*sqlparser.SQLVal
+		for _,e := range fe.Exprs {
+			ae,ok := e.(*sqlparser.AliasedExpr)
+			if !ok { return notsupp }
+			v,err := lockArg(ae.Expr)
+			if err!=nil { return err }
+			args = append(args,v)
+		}
+
+		var res int64
+		null := false
+		switch strings.ToLower(fe.Name.String()) {
+		case "get_lock":
+			if len(args)!=2 { return fmt.Errorf("Incorrect parameter count in the call to native function 'GET_LOCK'") }
+			if args[0]==nil { null = true; break }
+			key := lockKey(string(args[0].Val))
+			timeout := -1.0
+			if args[1]!=nil { timeout,_ = strconv.ParseFloat(string(args[1].Val),64) }
+			got,err := g.getLock(l,db,key,timeout)
+			if err!=nil { return err }
+			if got {
+				cd.locks[key]++
+				res = 1
+			}
+		case "release_lock":
+			if len(args)!=1 { return fmt.Errorf("Incorrect parameter count in the call to native function 'RELEASE_LOCK'") }
+			if args[0]==nil { null = true; break }
+			key := lockKey(string(args[0].Val))
+			if cd.locks[key]>0 {
+				if _,err = l.Unlock(db,key); err!=nil { return err }
+				if cd.locks[key]--; cd.locks[key]==0 { delete(cd.locks,key) }
+				res = 1
+				break
+			}
+			/* Not ours: 0 if held by someone else, NULL if it doesn't exist. */
+			free,err := isFree(l,db,key)
+			if err!=nil { return err }
+			null = free
+		case "is_free_lock":
+			if len(args)!=1 { return fmt.Errorf("Incorrect parameter count in the call to native function 'IS_FREE_LOCK'") }
+			if args[0]==nil { null = true; break }
+			key := lockKey(string(args[0].Val))
+			if cd.locks[key]>0 { break }
+			free,err := isFree(l,db,key)
+			if err!=nil { return err }
+			if free { res = 1 }
+		case "release_all_locks":
+			if len(args)!=0 { return fmt.Errorf("Incorrect parameter count in the call to native function 'RELEASE_ALL_LOCKS'") }
+			if err = l.UnlockAll(db); err!=nil { return err }
+			for _,n := range cd.locks { res += int64(n) }
+			cd.locks = make(map[int64]int)
+		default:
+			return notsupp
+		}
+		if !null { row[i] = res }
+	}
+	return callback(&sqltypes.Result{Fields:schemaToFields(sch),Rows:[][]sqltypes.Value{rowToSQL(sch,row)},RowsAffected:1})
+}
+
+/*
+Tries to acquire the lock, until the timeout (in seconds) expires. A negative
+timeout means infinite.
+*/
+func (g *Gateway) getLock(l Locker,db GenericDB,key int64,timeout float64) (bool,error) {
+	deadline := time.Now().Add(time.Duration(timeout*float64(time.Second)))
+	for {
+		got,err := l.TryLock(db,key)
+		if err!=nil || got { return got,err }
+		wait := LockPollInterval
+		if timeout>=0 {
+			left := time.Until(deadline)
+			if left<=0 { return false,nil }
+			if left<wait { wait = left }
+		}
+		time.Sleep(wait)
+	}
+}
+
+func isFree(l Locker,db GenericDB,key int64) (bool,error) {
+	got,err := l.TryLock(db,key)
+	if err!=nil || !got { return false,err }
+	_,err = l.Unlock(db,key)
+	return true,err
+}
+
+/*
+Parses LOCK {TABLE | TABLES} tbl [[AS] alias] {READ [LOCAL] | [LOW_PRIORITY] WRITE} [, ...]
+*/
+func parseLockTables(query,schema string) (tables []TableLock,err error) {
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(query),stmt:"LOCK TABLES"}
+	p.next()
+	if err = p.expect("lock"); err!=nil { return }
+	if err = p.expect("tables","table"); err!=nil { return }
+	for {
+		var tl TableLock
+		var name string
+		if name,err = p.ident(); err!=nil { return }
+		tl.Table.Name = sqlparser.NewTableIdent(name)
+		if p.typ=='.' {
+			p.next()
+			tl.Table.Qualifier = tl.Table.Name
+			if name,err = p.ident(); err!=nil { return }
+			tl.Table.Name = sqlparser.NewTableIdent(name)
+		}
+		if tl.Table.Qualifier.IsEmpty() && schema!="" { tl.Table.Qualifier = sqlparser.NewTableIdent(schema) }
+		if p.accept("as") {
+			if _,err = p.ident(); err!=nil { return }
+		} else if w := p.word(); w!="read" && w!="write" && w!="low_priority" {
+			if _,err = p.ident(); err!=nil { return }
+		}
+		switch {
+		case p.accept("read"):
+			p.accept("local")
+		case p.accept("low_priority"):
+			if err = p.expect("write"); err!=nil { return }
+			tl.Write = true
+		default:
+			if err = p.expect("read","write"); err!=nil { return }
+			tl.Write = true
+		}
+		tables = append(tables,tl)
+		if p.typ!=',' { break }
+		p.next()
+	}
+	if p.typ==';' { p.next() }
+	if p.typ!=0 {
+		return nil,fmt.Errorf("LOCK TABLES: syntax error near '%s'",p.val)
+	}
+	return
+}
+
+/*
+Implements LOCK TABLES: Like in MySQL, an active transaction is committed. The
+tables are locked in a transaction, that lasts until UNLOCK TABLES, START
+TRANSACTION or disconnect. The statements in between are committed then, except
+for failed ones, which are rolled back (see locked). COMMIT and ROLLBACK don't
+end it.
+*/
+func (g *Gateway) lockTables(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	l,ok := g.SF.(Locker)
+	if !ok { return fmt.Errorf("LOCK TABLES is not supported by this backend") }
+	tables,err := parseLockTables(query,c.SchemaName)
+	if err!=nil { return err }
+	if g.Firewall!=nil {
+		for _,tl := range tables {
+			/* Locking doesn't touch any rows, the WHERE clause only satisfies RequireWhere. */
+			where := sqlparser.NewWhere(sqlparser.WhereStr,sqlparser.BoolVal(true))
+			var st sqlparser.Statement = &sqlparser.Select{From:sqlparser.TableExprs{&sqlparser.AliasedTableExpr{Expr:tl.Table}},Where:where}
+			if tl.Write { st = &sqlparser.Update{TableExprs:sqlparser.TableExprs{&sqlparser.AliasedTableExpr{Expr:tl.Table}},Where:where} }
+			if _,err = g.Firewall.Check(c.User,c.SchemaName,st); err!=nil { return err }
+		}
+	}
+
+	cd := c.ClientData.(*ClientData)
+	if cd.Tx!=nil {
+		err = cd.Tx.Commit()
+		cd.Tx,cd.tableLocks = nil,nil
+		if err!=nil { g.unpin(cd); return err }
+	}
+	if err = g.beginLockTx(cd,l,tables); err!=nil { return err }
+	cd.LastWrite = time.Now()
+	return callback(new(sqltypes.Result))
+}
+
+/*
+Starts the transaction holding the table locks.
+*/
+func (g *Gateway) beginLockTx(cd *ClientData,l Locker,tables []TableLock) error {
+	tx,err := g.begin(cd)
+	if err!=nil { cd.tableLocks = nil; return err }
+	if err = l.LockTables(tx,tables); err!=nil {
+		tx.Rollback()
+		cd.tableLocks = nil
+		g.unpin(cd)
+		return err
+	}
+	cd.Tx,cd.tableLocks = tx,tables
+	return nil
+}
+
+/*
+Runs a statement. Under LOCK TABLES, the statements share the transaction holding
+the locks, but MySQL commits each of them on its own. So that a failed statement
+neither aborts the transaction nor takes the others with it, the statement runs
+in a savepoint, which is rolled back on error.
+*/
+func (g *Gateway) locked(c *mysql.Conn,run func() error) error {
+	cd := c.ClientData.(*ClientData)
+	if cd.tableLocks==nil { return run() }
+	tx := cd.Tx
+	if _,err := tx.Exec("SAVEPOINT my2any_locked"); err!=nil { return err }
+	err := run()
+	if err!=nil {
+		if _,rerr := tx.Exec("ROLLBACK TO SAVEPOINT my2any_locked"); rerr!=nil {
+			return fmt.Errorf("%v (and the LOCK TABLES transaction is aborted: %v)",err,rerr)
+		}
+	}
+	if _,rerr := tx.Exec("RELEASE SAVEPOINT my2any_locked"); rerr!=nil && err==nil { err = rerr }
+	return err
+}
+
+/*
+Implements UNLOCK TABLES: Commits the transaction holding the table locks.
+*/
+func (g *Gateway) unlockTables(c *mysql.Conn,callback func(*sqltypes.Result) error) error {
+	cd := c.ClientData.(*ClientData)
+	if cd.tableLocks!=nil {
+		err := cd.Tx.Commit()
+		cd.Tx,cd.tableLocks = nil,nil
+		g.unpin(cd)
+		if err!=nil { return err }
+	}
+	return callback(new(sqltypes.Result))
+}
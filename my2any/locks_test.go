@@ -0,0 +1,123 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "database/sql/driver"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "fmt"
+import "reflect"
+import "sync"
+import "testing"
+
+func TestParseLockTables(t *testing.T) {
+	cases := []struct{
+		query string
+		want  []string
+	}{
+		{"LOCK TABLES t READ",[]string{"s.t read"}},
+		{"lock table t write, u as x read local, v y low_priority write;",[]string{"s.t write","s.u read","s.v write"}},
+		{"LOCK TABLES o.t WRITE, `a b` READ",[]string{"o.t write","s.`a b` read"}},
+		{"LOCK TABLES t",nil},
+		{"LOCK TABLES t READ WRITE",nil},
+		{"LOCK TABLES t AS READ",nil},
+	}
+	for _,c := range cases {
+		tables,err := parseLockTables(c.query,"s")
+		if c.want==nil {
+			if err==nil { t.Errorf("parseLockTables(%q): no error",c.query) }
+			continue
+		}
+		if err!=nil {
+			t.Errorf("parseLockTables(%q): %v",c.query,err)
+			continue
+		}
+		var got []string
+		for _,tl := range tables {
+			mode := "read"
+			if tl.Write { mode = "write" }
+			got = append(got,sqlparser.String(tl.Table)+" "+mode)
+		}
+		if !reflect.DeepEqual(got,c.want) {
+			t.Errorf("parseLockTables(%q) = %q, want %q",c.query,got,c.want)
+		}
+	}
+}
+
+/* A database/sql driver, that logs the statements instead of executing them. */
+type logDriver struct{
+	lock sync.Mutex
+	log  []string
+}
+func (d *logDriver) logged(s string) {
+	d.lock.Lock(); defer d.lock.Unlock()
+	d.log = append(d.log,s)
+}
+func (d *logDriver) Open(name string) (driver.Conn,error) { return logConn{d},nil }
+
+type logConn struct{ d *logDriver }
+func (c logConn) Prepare(query string) (driver.Stmt,error) { return logStmt{c.d,query},nil }
+func (c logConn) Close() error { return nil }
+func (c logConn) Begin() (driver.Tx,error) { c.d.logged("begin"); return c,nil }
+func (c logConn) Commit() error { c.d.logged("commit"); return nil }
+func (c logConn) Rollback() error { c.d.logged("rollback"); return nil }
+
+type logStmt struct{
+	d     *logDriver
+	query string
+}
+func (s logStmt) Close() error { return nil }
+func (s logStmt) NumInput() int { return -1 }
+func (s logStmt) Exec(args []driver.Value) (driver.Result,error) {
+	s.d.logged(s.query)
+	return driver.RowsAffected(1),nil
+}
+func (s logStmt) Query(args []driver.Value) (driver.Rows,error) {
+	return nil,fmt.Errorf("logDriver: queries are not supported")
+}
+
+var testDriver = new(logDriver)
+func init() { sql.Register("my2any-log",testDriver) }
+
+type logLocker struct{ DefaultSpecialFeaturesClass }
+func (logLocker) TryLock(db GenericDB, key int64) (bool,error) { return true,nil }
+func (logLocker) Unlock(db GenericDB, key int64) (bool,error) { return true,nil }
+func (logLocker) UnlockAll(db GenericDB) error { return nil }
+func (logLocker) LockTables(tx *sql.Tx, tables []TableLock) error {
+	_,err := tx.Exec("lock")
+	return err
+}
+
+func TestLockTablesRollback(t *testing.T) {
+	db,err := sql.Open("my2any-log","")
+	if err!=nil { t.Fatal(err) }
+	defer db.Close()
+	g := &Gateway{DB:db,Syn:DefaultSyntaxer,SF:logLocker{}}
+	c := &mysql.Conn{ClientData:new(ClientData)}
+	testDriver.log = nil
+	for _,q := range []string{"lock tables t write","insert into t values (1)","rollback","commit","unlock tables"} {
+		if err = g.ComQuery(c,q,func(*sqltypes.Result) error { return nil }); err!=nil { t.Fatalf("%s: %v",q,err) }
+	}
+	/* The INSERT stays, and the locks are held until UNLOCK TABLES. */
+	want := []string{"begin","lock","SAVEPOINT my2any_locked","insert into t values (1)","RELEASE SAVEPOINT my2any_locked","commit"}
+	if !reflect.DeepEqual(testDriver.log,want) {
+		t.Errorf("statements %q, want %q",testDriver.log,want)
+	}
+}
@@ -23,6 +23,8 @@ import "gopkg.in/src-d/go-vitess.v0/sqltypes"
 import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
 import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
 import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "github.com/a-mail-group/yoursql/querylog"
+import "github.com/a-mail-group/yoursql/metrics"
 import "regexp"
 import "reflect"
 import "strings"
@@ -31,6 +33,7 @@ import "time"
 import "fmt"
 
 var descRx = regexp.MustCompile(`^[dD][eE][sS][cC](?:[rR][iI][bB][eE])?\s+(\S+)`)
+var flushRx = regexp.MustCompile(`^(?i)flush\s+tables?(?:\s+(.*?))?\s*;?\s*$`)
 
 type Converter interface{
 	Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interface{})
@@ -123,13 +126,61 @@ func (DefaultSpecialFeaturesClass) Perform(db GenericDB,cmd string,args ...strin
 func (DefaultSpecialFeaturesClass) Rewrite(db GenericDB,ast sqlparser.Statement,pvp *int) (string,bool) { return "",false }
 var DefaultSpecialFeatures SpecialFeatures = DefaultSpecialFeaturesClass{}
 
+/*
+Optional interface for SpecialFeatures, that cache catalog information.
+The Gateway calls it, when it executes DDL or FLUSH TABLES.
+*/
+type CatalogCache interface{
+	InvalidateTable(schema, name string)
+	FlushCatalog()
+}
+
+/*
+Optional interface for SpecialFeatures, that implement DESCRIBE themselves
+(instead of Perform("show.columns",...)).
+*/
+type Describer interface{
+	Describe(db GenericDB, schema, table string) (*sqltypes.Result,error)
+}
+
+/*
+Optional interface for SpecialFeatures, whose Rewrite depends on the values of
+literals. The statement cache translates statements with bind variables
+(:v1, :v2, ...) instead of literals, so statements, for which DependsOnLiterals
+returns true, are not cached.
+*/
+type LiteralRewriter interface{
+	DependsOnLiterals(db GenericDB, ast sqlparser.Statement) bool
+}
+
 
 type ClientData struct{
 	Tx *sql.Tx
+	
+	/* The time of the last write, for read-your-writes routing. */
+	LastWrite time.Time
+	
+	/* The connection pinned to the client, while it holds named locks. */
+	Conn *sql.Conn
+	
+	/* Named locks held (by key), and the tables locked by LOCK TABLES. */
+	locks map[int64]int
+	tableLocks []TableLock
+	
+	/* The character sets of the queries and of the results. */
+	client,results *Charset
 }
 func (c *ClientData) Destroy() {
 	if c.Tx!=nil {
-		c.Tx.Rollback()
+		/* Under LOCK TABLES, MySQL would have committed every statement. */
+		if c.tableLocks!=nil {
+			c.Tx.Commit()
+		} else {
+			c.Tx.Rollback()
+		}
+	}
+	if c.Conn!=nil {
+		c.Conn.Close()
 	}
 }
 
@@ -138,25 +189,109 @@ type Gateway struct{
 	CC  Converter
 	Syn Syntaxer
 	SF  SpecialFeatures
+	
+	/* Optional statement cache. */
+	Cache *StmtCache
+	
+	/* Optional read replicas of DB. */
+	Replicas *ReplicaSet
+	
+	/* Optional query logger. */
+	Log querylog.Logger
+	
+	/* Optional metrics. */
+	Metrics *metrics.Metrics
+	
+	/* Optional statement firewall. */
+	Firewall Firewall
+	
+	/* Optional rewrite rules, applied before Syn.Preprocess. */
+	Rules *RewriteRules
+	
+	/*
+	The directory on the gateway host, SELECT ... INTO OUTFILE writes to.
+	If empty, INTO OUTFILE is refused.
+	*/
+	ExportDir string
+	
+	/*
+	The directory on the gateway host, LOAD DATA INFILE reads from.
+	If empty, LOAD DATA INFILE is refused.
+	*/
+	ImportDir string
+	
+	/*
+	Results are sent in chunks of about BatchBytes (default: DefaultBatchBytes).
+	Results larger than MaxResultBytes (if >0) fail with ER_TOO_BIG_SELECT.
+	*/
+	BatchBytes int
+	MaxResultBytes int64
+	
+	/*
+	If >0 and the SpecialFeatures implement Cursors, SELECTs are fetched with a
+	cursor, FetchSize rows at a time (except for those with a smaller LIMIT).
+	*/
+	FetchSize int
+	
+	/* The users, that may use the admin statements (SHOW GATEWAY ..., GATEWAY ...). */
+	Admins []string
+	
+	sessions sessions
 }
 func (g *Gateway) NewConnection(c *mysql.Conn) {
+	if g.Metrics!=nil { g.Metrics.ConnectionOpened() }
 	c.ClientData = new(ClientData)
+	g.sessions.add(c)
 }
 func (g *Gateway) ConnectionClosed(c *mysql.Conn) {
+	if g.Metrics!=nil { g.Metrics.ConnectionClosed() }
+	g.sessions.remove(c)
 	cd := c.ClientData.(*ClientData)
 	c.ClientData = nil
+	g.releaseLocks(cd)
 	cd.Destroy()
 }
 func (g *Gateway) getDB(c *mysql.Conn) GenericDB {
-	tx := c.ClientData.(*ClientData).Tx
-	if tx==nil { return g.DB }
-	return tx
+	cd := c.ClientData.(*ClientData)
+	if cd.Tx!=nil { return cd.Tx }
+	if cd.Conn!=nil { return connDB{cd.Conn} }
+	return g.DB
 }
 func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	cd := c.ClientData.(*ClientData)
+	cd.initCharset(c)
+	query,err := cd.decode(query)
+	if err!=nil { return err }
+	callback = cd.encodeResults(callback)
+	g.sessions.start(c,query)
+	defer g.sessions.done(c)
+	
+	if g.Log==nil && g.Metrics==nil { return g.comQuery(c,query,callback,nil) }
+	le := &querylog.Entry{Time:time.Now(),User:c.User,Schema:c.SchemaName,Query:query}
+	err = g.comQuery(c,query,func(r *sqltypes.Result) error {
+		le.Rows += r.RowsAffected
+		return callback(r)
+	},le)
+	le.Duration = time.Since(le.Time)
+	if err!=nil { le.Error = err.Error() }
+	if g.Metrics!=nil { g.Metrics.Query(sqlparser.Preview(query),le.Duration,le.Rows,err) }
+	if g.Log!=nil { g.Log.Log(le) }
+	return err
+}
+func (g *Gateway) comQuery(c *mysql.Conn,query string,callback func(*sqltypes.Result) error,le *querylog.Entry) error {
+	if adminRx.MatchString(query) {
+		return g.admin(c,query,callback)
+	}
 	pv := sqlparser.Preview(query)
 	switch pv {
 	case sqlparser.StmtBegin:
 		cd := c.ClientData.(*ClientData)
+		if cd.tableLocks!=nil {
+			/* Like in MySQL, START TRANSACTION releases the table locks. */
+			err := cd.Tx.Commit()
+			cd.Tx,cd.tableLocks = nil,nil
+			if err!=nil { g.unpin(cd); return err }
+		}
 		if cd.Tx!=nil {
 			return &mysql.SQLError{
 				mysql.ERCantDoThisDuringAnTransaction,
@@ -165,7 +300,7 @@ func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Re
 				query,
 			}
 		}
-		tx,err := g.DB.Begin()
+		tx,err := g.begin(cd)
 		if err!=nil { return err }
 		cd.Tx = tx
 		return callback(new(sqltypes.Result))
@@ -174,11 +309,14 @@ func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Re
 		if cd.Tx==nil {
 			return fmt.Errorf("transaction required")
 		}
+		/* Under LOCK TABLES, MySQL has committed every statement already and keeps the locks. */
+		if cd.tableLocks!=nil { return callback(new(sqltypes.Result)) }
 		err := cd.Tx.Commit()
 		if err!=nil {
 			cd.Tx.Rollback()
 		}
 		cd.Tx = nil
+		g.unpin(cd)
 		if err==nil { err = callback(new(sqltypes.Result)) }
 		return err
 	case sqlparser.StmtRollback:
@@ -186,46 +324,187 @@ func (g *Gateway) ComQuery(c *mysql.Conn,query string,callback func(*sqltypes.Re
 		if cd.Tx==nil {
 			return fmt.Errorf("transaction required")
 		}
+		/* Under LOCK TABLES, there is nothing to roll back: MySQL has committed every statement. */
+		if cd.tableLocks!=nil { return callback(new(sqltypes.Result)) }
 		err := cd.Tx.Rollback()
 		cd.Tx = nil
+		g.unpin(cd)
 		if err==nil { err = callback(new(sqltypes.Result)) }
 		return err
 	case sqlparser.StmtShow:
 		return g.show(c,query,callback)
-	case sqlparser.StmtOther:
+	case sqlparser.StmtSet:
+		if ok,err := g.setCharset(c,query); ok {
+			if err!=nil { return err }
+			return callback(new(sqltypes.Result))
+		}
+	case sqlparser.StmtOther,sqlparser.StmtUnknown:
 		if descRx.MatchString(query) {
+			if d,ok := g.SF.(Describer); ok {
+				sr,err := d.Describe(g.getDB(c),c.SchemaName,descRx.FindStringSubmatch(query)[1])
+				if err!=nil { return err }
+				return callback(sr)
+			}
 			rs,err := g.SF.Perform(g.getDB(c),"show.columns",c.SchemaName,descRx.FindStringSubmatch(query)[1])
 			if err!=nil { return err }
 			return g.streamRows(c,rs,callback)
 		}
+		if callRx.MatchString(query) {
+			return g.locked(c,func() error { return g.call(c,query,callback) })
+		}
+		if lockTablesRx.MatchString(query) {
+			return g.lockTables(c,query,callback)
+		}
+		if unlockTablesRx.MatchString(query) {
+			return g.unlockTables(c,callback)
+		}
+		if loadRx.MatchString(query) {
+			return g.locked(c,func() error { return g.loadData(c,query,callback,le) })
+		}
+		if flushRx.MatchString(query) {
+			g.flushTables(c.SchemaName,flushRx.FindStringSubmatch(query)[1])
+			return callback(new(sqltypes.Result))
+		}
+	}
+	return g.locked(c,func() error { return g.execute(c,query,pv,callback,le) })
+}
+
+/*
+Executes the statements, that don't change the transaction state.
+*/
+func (g *Gateway) execute(c *mysql.Conn,query string,pv int,callback func(*sqltypes.Result) error,le *querylog.Entry) error {
+	if pv==sqlparser.StmtSelect && lockFuncRx.MatchString(query) {
+		return g.lockFunc(c,query,callback)
+	}
+	/* sqlparser.Preview doesn't look into the versioned comments of mysqldump. */
+	if pv==sqlparser.StmtDDL || strings.Contains(query,"/*!") {
+		if oq := unwrapVersioned(query); objectRx.MatchString(oq) {
+			return g.objectDDL(c,oq,callback)
+		}
+	}
+	if pv==sqlparser.StmtSelect && outfileRx.MatchString(query) {
+		sel,ld,dump,err := parseOutfile(query)
+		if err!=nil { return err }
+		if ld!=nil { return g.exportSelect(c,sel,ld,dump,callback) }
 	}
 	
-	st,err := decodeSql(query)
-	if err!=nil { return err }
-	g.Syn.Preprocess(st,c.SchemaName)
-	
-	var nq string
+	st,nq,err := g.translate(c,query,&pv)
+	if err!=nil {
+		if _,denied := err.(*mysql.SQLError); !denied && g.Metrics!=nil { g.Metrics.TranslationFailed() }
+		return err
+	}
+	if le!=nil { le.Translated = nq }
 	
-	if nnq,ok := g.SF.Rewrite(g.getDB(c),st,&pv) ; ok {
-		nq = nnq
-	} else {
-		nq = g.Syn.EncodeAny(st)
+	switch pv {
+	case sqlparser.StmtInsert,sqlparser.StmtReplace,sqlparser.StmtUpdate,sqlparser.StmtDelete,sqlparser.StmtDDL,StmtxInsertReturning:
+		c.ClientData.(*ClientData).LastWrite = time.Now()
 	}
 	
 	switch pv {
 	case sqlparser.StmtDDL:
-		//fmt.Println(nq)
-		return g.executeScript(c,nq,callback)
+		err = g.executeScript(c,nq,callback)
+		g.invalidate(st)
+		return err
 	case sqlparser.StmtInsert,sqlparser.StmtUpdate,sqlparser.StmtDelete:
 		return g.executeScript(c,nq,callback)
 	case sqlparser.StmtSelect:
-		return g.executeQuery(c,nq,callback)
+		return g.executeRead(c,query,nq,callback)
 	case StmtxInsertReturning:
 		return g.executeScriptReturning(c,nq,callback)
 	}
 	return fmt.Errorf("Sorry!")
 }
 
+/*
+Translates the query into the backend's dialect. The returned statement is nil,
+if the translation was taken from the statement cache.
+*/
+func (g *Gateway) translate(c *mysql.Conn,query string,pvp *int) (sqlparser.Statement,string,error) {
+	var st sqlparser.Statement
+	var err error
+	if g.Firewall!=nil {
+		st,err = decodeSql(query)
+		if err!=nil { return nil,"",err }
+		modified,err := g.Firewall.Check(c.User,c.SchemaName,st)
+		if err!=nil { return nil,"",err }
+		/* A modified statement differs from the cached translation. */
+		if modified { return g.encode(c,query,st,pvp) }
+	}
+	
+	if g.Cache!=nil {
+		if nq,ok := g.Cache.translate(g,g.getDB(c),c.SchemaName,query,pvp); ok {
+			return nil,nq,nil
+		}
+	}
+	
+	if st==nil {
+		st,err = decodeSql(query)
+		if err!=nil { return nil,"",err }
+	}
+	return g.encode(c,query,st,pvp)
+}
+func (g *Gateway) encode(c *mysql.Conn,query string,st sqlparser.Statement,pvp *int) (sqlparser.Statement,string,error) {
+	if g.Rules==nil {
+		g.Syn.Preprocess(st,c.SchemaName)
+	} else {
+		var fp string
+		lits := []*sqlparser.SQLVal{}
+		if key,_,ls,ok := normalize(query); ok {
+			fp = key
+			for _,l := range ls { lits = append(lits,literal(l)) }
+		}
+		st = g.rewrite(st,fp,c.SchemaName,lits)
+	}
+	if err := g.check(st); err!=nil { return nil,"",err }
+	
+	if nnq,ok := g.SF.Rewrite(g.getDB(c),st,pvp) ; ok {
+		return st,nnq,nil
+	}
+	return st,g.Syn.EncodeAny(st),nil
+}
+
+/* Calls the Checker of the Syntaxer, if it has one. */
+func (g *Gateway) check(st sqlparser.Statement) error {
+	if ch,ok := g.Syn.(Checker); ok { return ch.Check(st) }
+	return nil
+}
+
+/*
+Invalidates cached information about the tables, the DDL statement refers to.
+*/
+func (g *Gateway) invalidate(st sqlparser.Statement) {
+	if g.Cache!=nil { g.Cache.Invalidate(st) }
+	cc,ok := g.SF.(CatalogCache)
+	if !ok { return }
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if tn,ok := node.(sqlparser.TableName); ok && !tn.Name.IsEmpty() {
+			cc.InvalidateTable(tn.Qualifier.String(),tn.Name.String())
+		}
+		return true,nil
+	},st)
+}
+
+/*
+Implements FLUSH TABLES [tbl_name [, tbl_name] ...]: Drops cached catalog
+information and translations.
+*/
+func (g *Gateway) flushTables(schema,list string) {
+	cc,_ := g.SF.(CatalogCache)
+	if strings.TrimSpace(list)=="" {
+		if g.Cache!=nil { g.Cache.Flush() }
+		if cc!=nil { cc.FlushCatalog() }
+		return
+	}
+	for _,tab := range strings.Split(list,",") {
+		ns,name := schema,strings.Trim(strings.TrimSpace(tab),"`")
+		if i := strings.Index(name,"."); i>=0 {
+			ns,name = strings.Trim(name[:i],"`"),strings.Trim(name[i+1:],"`")
+		}
+		if g.Cache!=nil { g.Cache.InvalidateTable(ns,name) }
+		if cc!=nil { cc.InvalidateTable(ns,name) }
+	}
+}
+
 func (g *Gateway) executeScriptReturning(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
 	rs,err := g.getDB(c).Query(query)
 	if err!=nil { return err }
@@ -236,10 +515,14 @@ func (g *Gateway) sendResultReturning(c *mysql.Conn,rs *sql.Rows,callback func(*
 	
 	sr := new(sqltypes.Result)
 	
+	/* Like MySQL, report the first generated id of a multi-row insert. */
 	for rs.Next() {
-		rs.Scan(&sr.InsertID)
+		if sr.RowsAffected==0 {
+			if err := rs.Scan(&sr.InsertID); err!=nil { return err }
+		}
 		sr.RowsAffected++
 	}
+	if err := rs.Err(); err!=nil { return err }
 	
 	return callback(sr)
 }
@@ -273,50 +556,85 @@ func (g *Gateway) sendResult(c *mysql.Conn,res sql.Result,callback func(*sqltype
 	
 	return callback(sr)
 }
-func (g *Gateway) executeQuery(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
-	rs,err := g.getDB(c).Query(query)
+func (g *Gateway) executeRead(c *mysql.Conn,query,nq string,callback func(*sqltypes.Result) error) error {
+	db := g.readDB(c,query)
+	if cur,ok := g.SF.(Cursors); ok && g.FetchSize>0 && !smallLimit(nq,g.FetchSize) {
+		return g.executeCursor(c,cur,db,nq,callback)
+	}
+	rs,err := db.Query(nq)
 	if err!=nil { return err }
 	return g.streamRows(c,rs,callback)
 }
 func (g *Gateway) streamRows(c *mysql.Conn,rs *sql.Rows,callback func(*sqltypes.Result) error) error {
-	defer rs.Close()
-	
-	cts,err := rs.ColumnTypes()
-	if err!=nil { return err }
-	lcts := len(cts)
-	sch := make(sqlv.Schema,lcts)
-	sca := make([]interface{},len(cts))
-	vls := make([]interface{},len(cts))
-	
-	for i,ct := range cts {
-		sch[i],sca[i] = g.CC.Convert(ct)
-	}
-	
-	
-	sr := new(sqltypes.Result)
-	sr.Fields = schemaToFields(sch)
+	return g.streamBatches(c,func(prev int) (*sql.Rows,error) {
+		if prev>=0 { return nil,nil }
+		return rs,nil
+	},callback)
+}
+
+/*
+Streams the rows of one or more *sql.Rows (as returned by next, until it returns
+nil) as a single result. next gets the number of rows of the previous batch (-1
+for the first one). The rows are passed to the callback in chunks of about
+BatchBytes. If the result exceeds MaxResultBytes, it fails with ER_TOO_BIG_SELECT.
+*/
+func (g *Gateway) streamBatches(c *mysql.Conn,next func(prev int) (*sql.Rows,error),callback func(*sqltypes.Result) error) error {
+	batch := g.BatchBytes
+	if batch<=0 { batch = DefaultBatchBytes }
 	
-	chunk := 0
+	var sch sqlv.Schema
+	var sca,vls []interface{}
+	var sr *sqltypes.Result
+	size,total := 0,int64(0)
 	
-	for rs.Next() {
-		err = rs.Scan(sca...)
+	for prev := -1; ; {
+		rs,err := next(prev)
 		if err!=nil { return err }
-		for i,scav := range sca {
-			vls[i] = deref(scav)
-		}
-		
-		if chunk>1024 {
-			err = callback(sr)
-			if err!=nil { return err }
+		if rs==nil { break }
+		if sch==nil {
+			cts,err := rs.ColumnTypes()
+			if err!=nil { rs.Close(); return err }
+			sch = make(sqlv.Schema,len(cts))
+			sca = make([]interface{},len(cts))
+			vls = make([]interface{},len(cts))
+			for i,ct := range cts {
+				sch[i],sca[i] = g.CC.Convert(ct)
+			}
 			sr = new(sqltypes.Result)
 			sr.Fields = schemaToFields(sch)
 		}
-		sr.Rows = append(sr.Rows,rowToSQL(sch,vls))
-		sr.RowsAffected++
 		
-		chunk += lcts
+		prev = 0
+		for rs.Next() {
+			prev++
+			if err = rs.Scan(sca...); err!=nil { rs.Close(); return err }
+			for i,scav := range sca {
+				vls[i] = deref(scav)
+			}
+			row := rowToSQL(sch,vls)
+			rsize := 0
+			for _,v := range row { rsize += len(v.Raw()) }
+			
+			total += int64(rsize)
+			if g.MaxResultBytes>0 && total>g.MaxResultBytes {
+				rs.Close()
+				return mysql.NewSQLError(ERTooBigSelect,"42000","The result exceeds the maximum result size of %d bytes",g.MaxResultBytes)
+			}
+			if size>0 && size+rsize>batch {
+				if err = callback(sr); err!=nil { rs.Close(); return err }
+				sr = new(sqltypes.Result)
+				sr.Fields = schemaToFields(sch)
+				size = 0
+			}
+			sr.Rows = append(sr.Rows,row)
+			sr.RowsAffected++
+			size += rsize
+		}
+		err = rs.Err()
+		rs.Close()
+		if err!=nil { return err }
 	}
-	
+	if sr==nil { return callback(new(sqltypes.Result)) }
 	return callback(sr)
 }
 
@@ -335,6 +653,7 @@ func schemaToFields(s sqlv.Schema) []*query.Field {
 		fields[i] = &query.Field{
 			Name: c.Name,
 			Type: c.Type.Type(),
+			Charset: fieldCharset(c.Type.Type()),
 		}
 	}
 
@@ -0,0 +1,160 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Firebird/InterBase dialect for my2any.
+
+The queries use ? parameters, as expected by github.com/nakagami/firebirdsql.
+AUTO_INCREMENT columns are created as identity columns, which requires
+Firebird 3.0 or later.
+*/
+package my2firebird
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "database/sql"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+
+// HACK! Rename this type so it doesn't clashes with method name!
+type ntype sqlv.Type
+
+/* Firebird pads CHAR(n) values, MySQL strips the trailing spaces. */
+type chartype struct {
+	ntype
+}
+func (chartype) SQL(i interface{}) sqltypes.Value {
+	if b,ok := i.([]byte); ok && b!=nil { return sqlv.Text.SQL(strings.TrimRight(string(b)," ")) }
+	if s,ok := i.(string); ok { return sqlv.Text.SQL(strings.TrimRight(s," ")) }
+	return sqlv.Text.SQL(nil)
+}
+
+type FirebirdConverter struct {
+	my2any.Converter
+}
+func (p FirebirdConverter) Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interface{}) {
+	switch nct.DatabaseTypeName() {
+	case "TEXT","CHAR":
+		col = &sqlv.Column{Name:nct.Name(),Type:chartype{sqlv.Text}}
+		scan = new(interface{})
+		return
+	}
+	return p.Converter.Convert(nct)
+}
+
+/*
+The names of simple identifiers are stored upper-cased, they are reported in
+lower case, the way they were (most likely) written.
+*/
+const (
+	qShowTables = `
+SELECT CASE WHEN TRIM(r.RDB$RELATION_NAME) = UPPER(TRIM(r.RDB$RELATION_NAME))
+		THEN LOWER(TRIM(r.RDB$RELATION_NAME)) ELSE TRIM(r.RDB$RELATION_NAME) END
+	FROM RDB$RELATIONS r
+WHERE COALESCE(r.RDB$SYSTEM_FLAG, 0) = 0 AND r.RDB$VIEW_BLR IS NULL
+ORDER BY 1
+`
+	qShowColumns = `
+SELECT
+	CASE WHEN TRIM(rf.RDB$FIELD_NAME) = UPPER(TRIM(rf.RDB$FIELD_NAME))
+		THEN LOWER(TRIM(rf.RDB$FIELD_NAME)) ELSE TRIM(rf.RDB$FIELD_NAME) END AS "Field",
+	CASE
+		WHEN f.RDB$FIELD_TYPE IN (7, 8, 16) AND f.RDB$FIELD_SCALE < 0 THEN
+			'numeric(' || f.RDB$FIELD_PRECISION || ',' || (-f.RDB$FIELD_SCALE) || ')'
+		WHEN f.RDB$FIELD_TYPE = 7 THEN 'smallint'
+		WHEN f.RDB$FIELD_TYPE = 8 THEN 'integer'
+		WHEN f.RDB$FIELD_TYPE = 16 THEN 'bigint'
+		WHEN f.RDB$FIELD_TYPE = 10 THEN 'float'
+		WHEN f.RDB$FIELD_TYPE = 27 THEN 'double precision'
+		WHEN f.RDB$FIELD_TYPE = 12 THEN 'date'
+		WHEN f.RDB$FIELD_TYPE = 13 THEN 'time'
+		WHEN f.RDB$FIELD_TYPE = 35 THEN 'timestamp'
+		WHEN f.RDB$FIELD_TYPE = 23 THEN 'boolean'
+		WHEN f.RDB$FIELD_TYPE = 14 THEN 'char(' || f.RDB$CHARACTER_LENGTH || ')'
+		WHEN f.RDB$FIELD_TYPE = 37 THEN 'varchar(' || f.RDB$CHARACTER_LENGTH || ')'
+		WHEN f.RDB$FIELD_TYPE = 261 AND f.RDB$FIELD_SUB_TYPE = 1 THEN 'blob sub_type text'
+		WHEN f.RDB$FIELD_TYPE = 261 THEN 'blob'
+		ELSE 'unknown'
+	END AS "Type",
+	CASE WHEN COALESCE(rf.RDB$NULL_FLAG, f.RDB$NULL_FLAG, 0) = 1 THEN 'NO' ELSE 'YES' END AS "Null",
+	CASE
+		WHEN EXISTS (SELECT 1 FROM RDB$RELATION_CONSTRAINTS rc JOIN RDB$INDEX_SEGMENTS s ON s.RDB$INDEX_NAME = rc.RDB$INDEX_NAME
+			WHERE rc.RDB$RELATION_NAME = rf.RDB$RELATION_NAME AND rc.RDB$CONSTRAINT_TYPE = 'PRIMARY KEY'
+			AND s.RDB$FIELD_NAME = rf.RDB$FIELD_NAME) THEN 'PRI'
+		WHEN EXISTS (SELECT 1 FROM RDB$RELATION_CONSTRAINTS rc JOIN RDB$INDEX_SEGMENTS s ON s.RDB$INDEX_NAME = rc.RDB$INDEX_NAME
+			WHERE rc.RDB$RELATION_NAME = rf.RDB$RELATION_NAME AND rc.RDB$CONSTRAINT_TYPE = 'UNIQUE'
+			AND s.RDB$FIELD_NAME = rf.RDB$FIELD_NAME) THEN 'UNIQUE'
+		ELSE ''
+	END AS "Key",
+	COALESCE(TRIM(SUBSTRING(CAST(rf.RDB$DEFAULT_SOURCE AS VARCHAR(8191)) FROM 9)), 'NULL') AS "Default",
+	CASE WHEN rf.RDB$IDENTITY_TYPE IS NOT NULL THEN 'auto_increment' ELSE '' END AS "Extra"
+	FROM RDB$RELATION_FIELDS rf
+	JOIN RDB$FIELDS f ON f.RDB$FIELD_NAME = rf.RDB$FIELD_SOURCE
+WHERE rf.RDB$RELATION_NAME = ?
+ORDER BY rf.RDB$FIELD_POSITION
+`
+	qIdentity = `SELECT TRIM(rf.RDB$FIELD_NAME) FROM RDB$RELATION_FIELDS rf WHERE rf.RDB$RELATION_NAME = ? AND rf.RDB$IDENTITY_TYPE IS NOT NULL`
+)
+
+/*
+Returns the name of a table (or column), as it is stored in the system tables.
+*/
+func storedName(name string) string {
+	s := Ident(name)
+	if strings.HasPrefix(s,`"`) { return name }
+	return s
+}
+
+type FirebirdSpecialFeatures struct {
+	my2any.SpecialFeatures
+}
+func (p FirebirdSpecialFeatures) Perform(db my2any.GenericDB,cmd string,args ...string) (*sql.Rows,error) {
+	switch cmd {
+	case "show.tables":
+		return db.Query(qShowTables)
+	case "show.columns":
+		return db.Query(qShowColumns,storedName(strings.Trim(args[1],"`")))
+	}
+	return p.SpecialFeatures.Perform(db,cmd,args...)
+}
+
+/*
+Rewrites single-row INSERT statements into tables with an identity column into
+INSERT ... RETURNING, so the generated id is returned. Firebird doesn't allow
+RETURNING on multi-row inserts.
+*/
+func (p FirebirdSpecialFeatures) Rewrite(db my2any.GenericDB,ast sqlparser.Statement,pvp *int) (string,bool) {
+	i,ok := ast.(*sqlparser.Insert)
+	if !ok { return "",false }
+	if rows,ok := i.Rows.(sqlparser.Values); !ok || len(rows)!=1 { return "",false }
+	var col string
+	err := db.QueryRow(qIdentity,storedName(i.Table.Name.String())).Scan(&col)
+	if err!=nil { return "",false }
+
+	buf := sqlparser.NewTrackedBuffer(FirebirdFormatter)
+	buf.Myprintf("%v returning %s",i,quoted(col))
+	*pvp = my2any.StmtxInsertReturning
+	return buf.String(),true
+}
+
+func init() {
+	my2any.Register("firebird",my2any.Dialect{Driver:"firebirdsql",Setup:func(g *my2any.Gateway,dsn string) {
+		g.CC = FirebirdConverter{g.CC}
+		g.Syn = FirebirdSyntaxer{Syntaxer:g.Syn,Formatter:FirebirdFormatter}
+		g.SF = FirebirdSpecialFeatures{g.SF}
+	}})
+}
@@ -0,0 +1,278 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2firebird
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "regexp"
+
+var simple = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$]*$`)
+
+var reserved = make(map[string]bool)
+
+func init() {
+	for _,w := range strings.Fields(`ADD ALL ALTER AND ANY AS AT AVG BEGIN BETWEEN BIGINT BLOB BOOLEAN BOTH BY
+		CASE CAST CHAR CHARACTER CHECK CLOSE COLLATE COLUMN COMMIT CONNECT CONSTRAINT COUNT CREATE CROSS
+		CURRENT CURRENT_DATE CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER CURSOR DATE DAY DEC DECIMAL DECLARE
+		DEFAULT DELETE DISTINCT DOUBLE DROP ELSE END ESCAPE EXECUTE EXISTS EXTERNAL EXTRACT FALSE FETCH FILTER
+		FLOAT FOR FOREIGN FROM FULL FUNCTION GLOBAL GRANT GROUP HAVING HOUR IN INDEX INNER INSERT INT INTEGER
+		INTO IS JOIN LEADING LEFT LIKE MAX MIN MINUTE MONTH NATURAL NOT NULL NUMERIC OF OFFSET ON OPEN OR ORDER
+		OUTER PARAMETER POSITION PRIMARY REAL RECORD_VERSION REFERENCES RETURNING RIGHT ROLLBACK ROW ROWS
+		SECOND SELECT SET SMALLINT SOME START SUM TABLE THEN TIME TIMESTAMP TO TRAILING TRIGGER TRUE UNION
+		UNIQUE UPDATE UPPER USER USING VALUE VALUES VARCHAR VARIABLE VARYING VIEW WHEN WHERE WHILE WITH YEAR`) {
+		reserved[w] = true
+	}
+}
+
+/*
+Firebird upper-cases unquoted identifiers, quoted identifiers are case-sensitive.
+
+Simple identifiers are emitted upper-cased (as Firebird would store them), all
+others (and reserved words) are quoted. So a table created as `users` can be
+accessed as `users` or `USERS`, but `user` becomes "user".
+*/
+func Ident(s string) string {
+	u := strings.ToUpper(s)
+	if simple.MatchString(s) && !reserved[u] { return u }
+	return `"`+strings.Replace(s,`"`,`""`,-1)+`"`
+}
+
+func quoted(s string) string {
+	return `"`+strings.Replace(s,`"`,`""`,-1)+`"`
+}
+
+const epoch = "timestamp '1970-01-01 00:00:00'"
+
+func FirebirdFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch v := node.(type) {
+	case sqlparser.ColIdent: buf.WriteString(Ident(v.String()))
+	case sqlparser.TableIdent: buf.WriteString(Ident(v.String()))
+	case *sqlparser.ColIdent: buf.WriteString(Ident(v.String()))
+	case *sqlparser.TableIdent: buf.WriteString(Ident(v.String()))
+	case sqlparser.TableName:
+		/* Firebird has no schemas. */
+		buf.Myprintf("%v",v.Name)
+	case *sqlparser.Limit:
+		if v==nil {
+		} else if v.Offset==nil {
+			buf.Myprintf(" rows %v",v.Rowcount)
+		} else {
+			buf.Myprintf(" rows (%v)+1 to (%v)+(%v)",v.Offset,v.Offset,v.Rowcount)
+		}
+	case *sqlparser.Select:
+		buf.Myprintf("select %v%s",v.Comments,v.Distinct)
+		for i,se := range v.SelectExprs {
+			if i>0 { buf.WriteString(", ") }
+			/* Keep the case of result columns, Firebird would return them upper-cased. */
+			if ae,ok := se.(*sqlparser.AliasedExpr); ok && ae.As.IsEmpty() {
+				if cn,ok := ae.Expr.(*sqlparser.ColName); ok {
+					buf.Myprintf("%v as %s",cn,quoted(cn.Name.String()))
+					continue
+				}
+			}
+			buf.Myprintf("%v",se)
+		}
+		if my2any.IsDual(v.From) {
+			buf.WriteString(" from rdb$database")
+		} else {
+			buf.Myprintf(" from %v",v.From)
+		}
+		buf.Myprintf("%v%v%v%v%v",v.Where,v.GroupBy,v.Having,v.OrderBy,v.Limit)
+		if strings.Contains(v.Lock,"update") { buf.WriteString(" for update with lock") }
+	case *sqlparser.AliasedExpr:
+		if v.As.IsEmpty() {
+			buf.Myprintf("%v",v.Expr)
+		} else {
+			buf.Myprintf("%v as %s",v.Expr,quoted(v.As.String()))
+		}
+	case *sqlparser.Insert:
+		rows,ok := v.Rows.(sqlparser.Values)
+		if !ok || len(rows)<2 {
+			node.Format(buf)
+			break
+		}
+		/* Firebird knows no multi-row VALUES. */
+		buf.Myprintf("insert %vinto %v%v ",v.Comments,v.Table,v.Columns)
+		for i,row := range rows {
+			if i>0 { buf.WriteString(" union all ") }
+			buf.Myprintf("select %v from rdb$database",sqlparser.Exprs(row))
+		}
+	case sqlparser.BoolVal:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case *sqlparser.SQLVal:
+		switch v.Type {
+		case sqlparser.StrVal:
+			/* Firebird knows no backslash escapes. */
+			buf.WriteString("'"+strings.Replace(string(v.Val),"'","''",-1)+"'")
+		default:
+			node.Format(buf)
+		}
+	case *sqlparser.IndexDefinition:
+		switch {
+		case v.Info.Primary: buf.WriteString("primary key (")
+		default: buf.WriteString("unique (")
+		}
+		for i,c := range v.Columns {
+			if i>0 { buf.WriteString(", ") }
+			buf.Myprintf("%v",c.Column)
+		}
+		buf.WriteString(")")
+	case *sqlparser.GroupConcatExpr:
+		buf.Myprintf("list(%s%v",v.Distinct,v.Exprs)
+		if v.Separator!="" {
+			sep := strings.TrimSuffix(strings.TrimPrefix(v.Separator," separator '"),"'")
+			buf.Myprintf(", %v",sqlparser.NewStrVal([]byte(sep)))
+		}
+		buf.WriteString(")")
+	case *sqlparser.SubstrExpr:
+		if v.To==nil {
+			buf.Myprintf("substring(%v from %v)",v.Name,v.From)
+		} else {
+			buf.Myprintf("substring(%v from %v for %v)",v.Name,v.From,v.To)
+		}
+	case *sqlparser.FuncExpr:
+		switch strings.ToLower(v.Name.String()) {
+		case "now","current_timestamp","sysdate","localtime","localtimestamp": buf.WriteString("current_timestamp")
+		case "curdate","current_date": buf.WriteString("current_date")
+		case "curtime","current_time": buf.WriteString("current_time")
+		case "unix_timestamp":
+			if len(v.Exprs)==0 {
+				buf.WriteString("datediff(second from "+epoch+" to current_timestamp)")
+			} else {
+				buf.WriteString("datediff(second from "+epoch+" to ")
+				buf.Myprintf("%v)",v.Exprs[0])
+			}
+		case "from_unixtime":
+			buf.Myprintf("dateadd(second, %v, ",my2any.Arg(v.Exprs,0))
+			buf.WriteString(epoch+")")
+		case "concat":
+			buf.WriteString("(")
+			for i,se := range v.Exprs {
+				if i>0 { buf.WriteString(" || ") }
+				buf.Myprintf("%v",se)
+			}
+			buf.WriteString(")")
+		case "ifnull": buf.Myprintf("coalesce(%v)",v.Exprs)
+		case "if": buf.Myprintf("iif(%v)",v.Exprs)
+		case "length","character_length": buf.Myprintf("char_length(%v)",v.Exprs)
+		case "lcase": buf.Myprintf("lower(%v)",v.Exprs)
+		case "ucase": buf.Myprintf("upper(%v)",v.Exprs)
+		case "locate": buf.Myprintf("position(%v)",v.Exprs)
+		case "database","schema": buf.WriteString("rdb$get_context('SYSTEM', 'DB_NAME')")
+		case "version": buf.WriteString("rdb$get_context('SYSTEM', 'ENGINE_VERSION')")
+		default:
+			node.Format(buf)
+		}
+	default:
+		node.Format(buf)
+	}
+}
+
+/*
+Maps MySQL column types to Firebird column types.
+*/
+func mapType(ct *sqlparser.ColumnType) {
+	t := strings.ToLower(ct.Type)
+	switch {
+	case t=="bool" || t=="boolean" || (t=="tinyint" && ct.Length!=nil && string(ct.Length.Val)=="1"):
+		ct.Type = "boolean"
+	case my2any.IsInteger(t):
+		switch {
+		case t=="bigint" || ct.Unsigned && (t=="int" || t=="integer"): ct.Type = "bigint"
+		case t=="tinyint" || (t=="smallint" && !ct.Unsigned): ct.Type = "smallint"
+		default: ct.Type = "integer"
+		}
+	case t=="double" || t=="real": ct.Type = "double precision"
+	case strings.HasSuffix(t,"text") || t=="json" || t=="enum" || t=="set":
+		ct.Type = "blob sub_type text"
+		ct.Length = nil
+		ct.EnumValues = nil
+	case strings.HasSuffix(t,"blob"):
+		ct.Type = "blob"
+		ct.Length = nil
+	case t=="datetime": ct.Type = "timestamp"
+	case t=="year": ct.Type = "smallint"
+	}
+	switch ct.Type {
+	case "boolean","bigint","smallint","integer","double precision","timestamp": ct.Length = nil
+	}
+	ct.Unsigned = false
+	ct.Zerofill = false
+	ct.Charset = ""
+	ct.Collate = ""
+	ct.Comment = nil
+	ct.OnUpdate = nil
+}
+
+/*
+Translates statements into Firebird's dialect. The embedded Formatter, which
+implements my2any.Binder, must be FirebirdFormatter.
+*/
+type FirebirdSyntaxer struct {
+	my2any.Syntaxer
+	my2any.Formatter
+}
+func (FirebirdSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
+	ddl,ok := ast.(*sqlparser.DDL)
+	if !ok || ddl.Action!=sqlparser.CreateStr || ddl.TableSpec==nil { return }
+	ddl.TableSpec.Options = ""
+	for _,col := range ddl.TableSpec.Columns {
+		mapType(&col.Type)
+		if !col.Type.Autoincrement { continue }
+		/* Identity columns require Firebird 3.0 or later. */
+		col.Type.Type += " generated by default as identity"
+		col.Type.Autoincrement = false
+	}
+}
+
+/*
+Firebird doesn't support (non-unique) KEY or INDEX clauses in CREATE TABLE, and
+can't execute multiple statements at once, so CREATE TABLE and CREATE INDEX are
+wrapped into an EXECUTE BLOCK.
+*/
+func encodeCreate(ddl *sqlparser.DDL) string {
+	extra := my2any.SplitIndexes(ddl.TableSpec)
+	buf := sqlparser.NewTrackedBuffer(FirebirdFormatter)
+	buf.Myprintf("%v",ddl)
+	if len(extra)==0 { return buf.String() }
+
+	stmts := []string{buf.String()}
+	for _,idx := range extra {
+		buf = sqlparser.NewTrackedBuffer(FirebirdFormatter)
+		buf.WriteString("create index ")
+		my2any.FormatIndex(buf,ddl.NewName,idx)
+		stmts = append(stmts,buf.String())
+	}
+	s := "execute block as begin\n"
+	for _,stmt := range stmts {
+		s += "\texecute statement '"+strings.Replace(stmt,"'","''",-1)+"';\n"
+	}
+	return s+"end"
+}
+
+func (FirebirdSyntaxer) EncodeAny(ast sqlparser.Statement) string {
+	if ddl,ok := ast.(*sqlparser.DDL); ok && ddl.Action==sqlparser.CreateStr && ddl.TableSpec!=nil {
+		return encodeCreate(ddl)
+	}
+	return my2any.Formatter(FirebirdFormatter).Encode(ast)
+}
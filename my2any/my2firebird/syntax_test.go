@@ -0,0 +1,65 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2firebird
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "testing"
+
+var syn = FirebirdSyntaxer{Syntaxer:my2any.DefaultSyntaxer,Formatter:FirebirdFormatter}
+
+func encode(t *testing.T,query string) string {
+	st,err := sqlparser.Parse(query)
+	if err!=nil { t.Fatalf("%s: %v",query,err) }
+	syn.Preprocess(st,"")
+	return syn.EncodeAny(st)
+}
+
+func TestFormatter(t *testing.T) {
+	cases := []struct{
+		query, want string
+	}{
+		{"select a, 'x' from t limit 5",`select A as "a", 'x' from T rows 5`},
+		{"select a from t limit 5, 10",`select A as "a" from T rows (5)+1 to (5)+(10)`},
+		{"select 1","select 1 from rdb$database"},
+		{"select b as `user` from s.`user`",`select B as "user" from "user"`},
+		{"insert into t(a) values (1), (2)","insert into T(A) select 1 from rdb$database union all select 2 from rdb$database"},
+	}
+	for _,c := range cases {
+		if got := encode(t,c.query); got!=c.want { t.Errorf("EncodeAny(%q) = %q; want %q",c.query,got,c.want) }
+	}
+}
+
+func TestEncodeCreate(t *testing.T) {
+	got := encode(t,"create table t (id int auto_increment, b int, primary key (id), key k (b))")
+	if !strings.HasPrefix(got,"execute block as begin\n\texecute statement 'create table T") || !strings.HasSuffix(got,"\texecute statement 'create index T_K on T (B)';\nend") {
+		t.Errorf("EncodeAny = %q",got)
+	}
+	if !strings.Contains(got,"ID integer generated by default as identity") || strings.Contains(got,"key k") {
+		t.Errorf("EncodeAny = %q",got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	lits := []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("it's"))}
+	want := `select A from T where B = 'it''s'`
+	if got := syn.Bind("select A from T where B = :v1",lits); got!=want {
+		t.Errorf("Bind = %q; want %q",got,want)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+MonetDB dialect for my2any.
+
+The queries use ? parameters, as expected by github.com/MonetDB/MonetDB-Go.
+String literals are sent without backslash escapes, which requires MonetDB
+Jul2021 (11.41) or later.
+
+MonetDB reports the last value drawn from a sequence with every INSERT, so
+the generated ids are returned by sql.Result.LastInsertId() and need no
+rewriting.
+*/
+package my2monetdb
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "database/sql"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "reflect"
+import "strings"
+import "time"
+import "fmt"
+
+// HACK! Rename this type so it doesn't clashes with method name!
+type ntype sqlv.Type
+
+/* Sends values of columns, the driver has no fixed Go type for, as text. */
+type anytype struct {
+	ntype
+}
+func (anytype) SQL(i interface{}) sqltypes.Value {
+	switch v := i.(type) {
+	case nil: return sqltypes.NULL
+	case []byte: return sqlv.Text.SQL(string(v))
+	case string: return sqlv.Text.SQL(v)
+	case time.Time: return sqlv.Text.SQL(v.Format("2006-01-02 15:04:05.999999"))
+	}
+	return sqlv.Text.SQL(fmt.Sprint(i))
+}
+
+type MonetConverter struct {
+	my2any.Converter
+}
+func (p MonetConverter) Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interface{}) {
+	switch {
+	case strings.ToLower(nct.DatabaseTypeName())=="decimal",
+		nct.ScanType()==nil,
+		nct.ScanType().Kind()==reflect.Interface:
+		col = &sqlv.Column{Name:nct.Name(),Type:anytype{sqlv.Text}}
+		scan = new(interface{})
+		return
+	}
+	return p.Converter.Convert(nct)
+}
+
+const (
+	qShowTables = `
+SELECT t.name FROM sys.tables t
+WHERE NOT t.system AND t.query IS NULL AND t.schema_id = (SELECT s.id FROM sys.schemas s WHERE s.name = CURRENT_SCHEMA)
+ORDER BY t.name
+`
+	qShowColumns = `
+SELECT
+	c.name AS "Field",
+	CASE
+		WHEN c.type IN ('varchar','char') THEN c.type || '(' || c.type_digits || ')'
+		WHEN c.type = 'decimal' THEN 'decimal(' || c.type_digits || ',' || c.type_scale || ')'
+		ELSE c.type
+	END AS "Type",
+	CASE WHEN c."null" THEN 'YES' ELSE 'NO' END AS "Null",
+	CASE
+		WHEN EXISTS (SELECT 1 FROM sys.keys k JOIN sys.objects o ON o.id = k.id
+			WHERE k.table_id = c.table_id AND k.type = 0 AND o.name = c.name) THEN 'PRI'
+		WHEN EXISTS (SELECT 1 FROM sys.keys k JOIN sys.objects o ON o.id = k.id
+			WHERE k.table_id = c.table_id AND k.type = 1 AND o.name = c.name) THEN 'UNIQUE'
+		ELSE ''
+	END AS "Key",
+	COALESCE(c."default", 'NULL') AS "Default",
+	CASE WHEN c."default" LIKE 'next value for %' THEN 'auto_increment' ELSE '' END AS "Extra"
+	FROM sys.columns c
+WHERE c.table_id = (SELECT t.id FROM sys.tables t WHERE t.name = ?
+	AND t.schema_id = (SELECT s.id FROM sys.schemas s WHERE s.name = CURRENT_SCHEMA))
+ORDER BY c.number
+`
+)
+
+type MonetSpecialFeatures struct {
+	my2any.SpecialFeatures
+}
+func (p MonetSpecialFeatures) Perform(db my2any.GenericDB,cmd string,args ...string) (*sql.Rows,error) {
+	switch cmd {
+	case "show.tables":
+		return db.Query(qShowTables)
+	case "show.columns":
+		return db.Query(qShowColumns,strings.Trim(args[1],"`"))
+	}
+	return p.SpecialFeatures.Perform(db,cmd,args...)
+}
+
+func init() {
+	my2any.Register("monetdb",my2any.Dialect{Driver:"monetdb",Setup:func(g *my2any.Gateway,dsn string) {
+		g.CC = MonetConverter{g.CC}
+		g.Syn = MonetSyntaxer{Syntaxer:g.Syn,Formatter:MonetFormatter}
+		g.SF = MonetSpecialFeatures{g.SF}
+	}})
+}
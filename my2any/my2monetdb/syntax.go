@@ -0,0 +1,202 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2monetdb
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+
+func quoted(s string) string {
+	return `"`+strings.Replace(s,`"`,`""`,-1)+`"`
+}
+
+func MonetFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch v := node.(type) {
+	case sqlparser.ColIdent: buf.WriteString(quoted(v.String()))
+	case sqlparser.TableIdent: buf.WriteString(quoted(v.String()))
+	case *sqlparser.ColIdent: buf.WriteString(quoted(v.String()))
+	case *sqlparser.TableIdent: buf.WriteString(quoted(v.String()))
+	case *sqlparser.Limit:
+		/* MonetDB expects LIMIT before OFFSET. */
+		if v==nil || v.Offset==nil {
+			node.Format(buf)
+		} else {
+			buf.Myprintf(" limit %v offset %v",v.Rowcount,v.Offset)
+		}
+	case *sqlparser.Select:
+		if my2any.IsDual(v.From) {
+			buf.Myprintf("select %v%s%v%v%v%v",v.Comments,v.Distinct,v.SelectExprs,v.Where,v.OrderBy,v.Limit)
+		} else {
+			node.Format(buf)
+		}
+	case *sqlparser.SQLVal:
+		switch v.Type {
+		case sqlparser.StrVal:
+			buf.WriteString("'"+strings.Replace(string(v.Val),"'","''",-1)+"'")
+		default:
+			node.Format(buf)
+		}
+	case *sqlparser.IndexDefinition:
+		switch {
+		case v.Info.Primary: buf.WriteString("primary key (")
+		default: buf.Myprintf("constraint %v unique (",v.Info.Name)
+		}
+		for i,c := range v.Columns {
+			if i>0 { buf.WriteString(", ") }
+			buf.Myprintf("%v",c.Column)
+		}
+		buf.WriteString(")")
+	case *sqlparser.GroupConcatExpr:
+		sep := strings.TrimSuffix(strings.TrimPrefix(v.Separator," separator '"),"'")
+		if v.Separator=="" { sep = "," }
+		buf.Myprintf("group_concat(%s%v, %v)",v.Distinct,v.Exprs,sqlparser.NewStrVal([]byte(sep)))
+	case *sqlparser.SubstrExpr:
+		if v.To==nil {
+			buf.Myprintf("substring(%v, %v)",v.Name,v.From)
+		} else {
+			buf.Myprintf("substring(%v, %v, %v)",v.Name,v.From,v.To)
+		}
+	case *sqlparser.FuncExpr:
+		switch strings.ToLower(v.Name.String()) {
+		case "sysdate","localtime","localtimestamp": buf.WriteString("now()")
+		case "unix_timestamp":
+			if len(v.Exprs)==0 {
+				buf.WriteString("sys.epoch(now())")
+			} else {
+				buf.Myprintf("sys.epoch(%v)",v.Exprs[0])
+			}
+		case "from_unixtime": buf.Myprintf("sys.epoch(cast(%v as int))",my2any.Arg(v.Exprs,0))
+		case "concat":
+			/* MonetDB's concat() takes exactly two arguments. */
+			buf.WriteString("(")
+			for i,se := range v.Exprs {
+				if i>0 { buf.WriteString(" || ") }
+				buf.Myprintf("%v",se)
+			}
+			buf.WriteString(")")
+		case "ifnull": buf.Myprintf("coalesce(%v)",v.Exprs)
+		case "if": buf.Myprintf("(case when %v then %v else %v end)",my2any.Arg(v.Exprs,0),my2any.Arg(v.Exprs,1),my2any.Arg(v.Exprs,2))
+		case "instr": buf.Myprintf("locate(%v, %v)",my2any.Arg(v.Exprs,1),my2any.Arg(v.Exprs,0))
+		case "rand": buf.WriteString("(rand() / 2147483647.0)")
+		case "database","schema": buf.WriteString("current_schema")
+		case "version": buf.WriteString("(select value from sys.environment where name = 'monet_version')")
+		default:
+			node.Format(buf)
+		}
+	default:
+		node.Format(buf)
+	}
+}
+
+/*
+Maps MySQL column types to MonetDB column types.
+*/
+func mapType(ct *sqlparser.ColumnType) {
+	t := strings.ToLower(ct.Type)
+	switch {
+	case t=="bool" || t=="boolean" || (t=="tinyint" && ct.Length!=nil && string(ct.Length.Val)=="1"):
+		ct.Type = "boolean"
+	case my2any.IsInteger(t):
+		switch {
+		case t=="mediumint": ct.Type = "int"
+		case t=="bigint": ct.Type = "bigint"
+		case ct.Unsigned && (t=="int" || t=="integer"): ct.Type = "bigint"
+		case ct.Unsigned && t=="smallint": ct.Type = "int"
+		case ct.Unsigned && t=="tinyint": ct.Type = "smallint"
+		}
+	case t=="float": ct.Type = "real"
+	case t=="real": ct.Type = "double"
+	case strings.HasSuffix(t,"text") || t=="enum" || t=="set":
+		ct.Type = "text"
+		ct.Length = nil
+		ct.EnumValues = nil
+	case strings.HasSuffix(t,"blob"):
+		ct.Type = "blob"
+		ct.Length = nil
+	case t=="datetime": ct.Type = "timestamp"
+	case t=="year": ct.Type = "smallint"
+	}
+	if my2any.IsInteger(strings.ToLower(ct.Type)) || ct.Type=="boolean" { ct.Length = nil }
+	ct.Unsigned = false
+	ct.Zerofill = false
+	ct.Charset = ""
+	ct.Collate = ""
+	ct.Comment = nil
+	ct.OnUpdate = nil
+}
+
+/*
+Translates statements into MonetDB's dialect. The embedded Formatter, which
+implements my2any.Binder, must be MonetFormatter.
+*/
+type MonetSyntaxer struct {
+	my2any.Syntaxer
+	my2any.Formatter
+}
+func (MonetSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
+	ddl,ok := ast.(*sqlparser.DDL)
+	if !ok || ddl.Action!=sqlparser.CreateStr || ddl.TableSpec==nil { return }
+	ddl.TableSpec.Options = ""
+	for _,col := range ddl.TableSpec.Columns {
+		mapType(&col.Type)
+	}
+}
+
+/*
+AUTO_INCREMENT columns draw their values from a sequence named
+<table>_<column>_seq, which is created along with the table. Non-unique
+indexes are emitted as separate CREATE INDEX statements. The names of indexes
+and unique constraints are prefixed with the table name.
+*/
+func encodeCreate(ddl *sqlparser.DDL) string {
+	ts := ddl.TableSpec
+	table := ddl.NewName.Name.String()
+	buf := sqlparser.NewTrackedBuffer(MonetFormatter)
+	for _,col := range ts.Columns {
+		if !col.Type.Autoincrement { continue }
+		seq := sqlparser.TableName{Qualifier:ddl.NewName.Qualifier,Name:sqlparser.NewTableIdent(table+"_"+col.Name.String()+"_seq")}
+		buf.Myprintf("create sequence %v as bigint;\n",seq)
+		nbuf := sqlparser.NewTrackedBuffer(MonetFormatter)
+		nbuf.Myprintf(" default next value for %v",seq)
+		col.Type.Type += nbuf.String()
+		col.Type.Autoincrement = false
+		col.Type.Default = nil
+	}
+
+	extra := my2any.SplitIndexes(ts)
+	for _,idx := range ts.Indexes {
+		if idx.Info.Primary || !idx.Info.Unique { continue }
+		/* Constraint names are unique per schema, like index names. */
+		name := idx.Info.Name.String()
+		if name=="" && len(idx.Columns)>0 { name = idx.Columns[0].Column.String() }
+		idx.Info.Name = sqlparser.NewColIdent(table+"_"+name)
+	}
+	buf.Myprintf("%v",ddl)
+	for _,idx := range extra {
+		buf.WriteString(";\ncreate index ")
+		my2any.FormatIndex(buf,ddl.NewName,idx)
+	}
+	return buf.String()
+}
+
+func (MonetSyntaxer) EncodeAny(ast sqlparser.Statement) string {
+	if ddl,ok := ast.(*sqlparser.DDL); ok && ddl.Action==sqlparser.CreateStr && ddl.TableSpec!=nil {
+		return encodeCreate(ddl)
+	}
+	return my2any.Formatter(MonetFormatter).Encode(ast)
+}
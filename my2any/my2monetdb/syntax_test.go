@@ -0,0 +1,63 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2monetdb
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "testing"
+
+var syn = MonetSyntaxer{Syntaxer:my2any.DefaultSyntaxer,Formatter:MonetFormatter}
+
+func encode(t *testing.T,query string) string {
+	st,err := sqlparser.Parse(query)
+	if err!=nil { t.Fatalf("%s: %v",query,err) }
+	syn.Preprocess(st,"")
+	return syn.EncodeAny(st)
+}
+
+func TestFormatter(t *testing.T) {
+	cases := []struct{
+		query, want string
+	}{
+		{"select a from t limit 5, 10",`select "a" from "t" limit 10 offset 5`},
+		{"select 1","select 1"},
+		{"select instr(a, 'x'), ifnull(a, 0) from t",`select locate('x', "a"), coalesce("a", 0) from "t"`},
+	}
+	for _,c := range cases {
+		if got := encode(t,c.query); got!=c.want { t.Errorf("EncodeAny(%q) = %q; want %q",c.query,got,c.want) }
+	}
+}
+
+func TestEncodeCreate(t *testing.T) {
+	got := encode(t,"create table t (id int auto_increment, a int, b int, primary key (id), unique key u (a), key k (b))")
+	if !strings.HasPrefix(got,"create sequence \"t_id_seq\" as bigint;\ncreate table \"t\"") || !strings.Contains(got,`default next value for "t_id_seq"`) {
+		t.Errorf("AUTO_INCREMENT column: %q",got)
+	}
+	if !strings.Contains(got,`constraint "t_u" unique ("a")`) || !strings.HasSuffix(got,";\ncreate index \"t_k\" on \"t\" (\"b\")") {
+		t.Errorf("indexes: %q",got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	lits := []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("it's"))}
+	want := `select "a" from "t" where "b" = 'it''s'`
+	if got := syn.Bind(`select "a" from "t" where "b" = :v1`,lits); got!=want {
+		t.Errorf("Bind = %q; want %q",got,want)
+	}
+}
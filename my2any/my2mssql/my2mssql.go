@@ -0,0 +1,247 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Microsoft SQL Server dialect for my2any.
+
+The queries use @p1, @p2, ... parameters, as expected by the "sqlserver" driver
+of github.com/denisenkom/go-mssqldb.
+*/
+package my2mssql
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "database/sql"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "fmt"
+
+// HACK! Rename this type so it doesn't clashes with method name!
+type ntype sqlv.Type
+
+/* Formats UNIQUEIDENTIFIER values, which are stored mixed-endian. */
+type guidtype struct {
+	ntype
+}
+func (guidtype) SQL(i interface{}) sqltypes.Value {
+	b,ok := i.([]byte)
+	if !ok || len(b)!=16 { return sqlv.Text.SQL(nil) }
+	return sqlv.Text.SQL(fmt.Sprintf("%X-%X-%X-%X-%X",
+		[]byte{b[3],b[2],b[1],b[0]},[]byte{b[5],b[4]},[]byte{b[7],b[6]},b[8:10],b[10:]))
+}
+
+/* Converts DECIMAL and MONEY values (scanned as []byte) to text. */
+type numtype struct {
+	ntype
+}
+func (numtype) SQL(i interface{}) sqltypes.Value {
+	if b,ok := i.([]byte); ok && b!=nil { return sqlv.Text.SQL(string(b)) }
+	return sqlv.Text.SQL(nil)
+}
+
+type MssqlConverter struct {
+	my2any.Converter
+}
+func (p MssqlConverter) Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interface{}) {
+	switch nct.DatabaseTypeName() {
+	case "UNIQUEIDENTIFIER":
+		col = &sqlv.Column{Name:nct.Name(),Type:guidtype{sqlv.Text}}
+		scan = new([]byte)
+		return
+	case "DECIMAL","NUMERIC","MONEY","SMALLMONEY":
+		col = &sqlv.Column{Name:nct.Name(),Type:numtype{sqlv.Text}}
+		scan = new([]byte)
+		return
+	}
+	return p.Converter.Convert(nct)
+}
+
+const (
+	qShowTables = `SELECT t.name FROM sys.tables t WHERE t.schema_id = SCHEMA_ID() ORDER BY t.name`
+	qShowColumns = `
+SELECT
+	c.name AS [Field],
+	TYPE_NAME(c.user_type_id) + CASE
+		WHEN TYPE_NAME(c.user_type_id) IN ('varchar','char','varbinary','binary') THEN
+			'(' + CASE WHEN c.max_length = -1 THEN 'max' ELSE CAST(c.max_length AS varchar(10)) END + ')'
+		WHEN TYPE_NAME(c.user_type_id) IN ('nvarchar','nchar') THEN
+			'(' + CASE WHEN c.max_length = -1 THEN 'max' ELSE CAST(c.max_length/2 AS varchar(10)) END + ')'
+		WHEN TYPE_NAME(c.user_type_id) IN ('decimal','numeric') THEN
+			'(' + CAST(c.precision AS varchar(10)) + ',' + CAST(c.scale AS varchar(10)) + ')'
+		ELSE ''
+	END AS [Type],
+	CASE WHEN c.is_nullable = 1 THEN 'YES' ELSE 'NO' END AS [Null],
+	CASE
+		WHEN EXISTS (SELECT 1 FROM sys.index_columns ic JOIN sys.indexes i ON i.object_id = ic.object_id AND i.index_id = ic.index_id
+			WHERE ic.object_id = c.object_id AND ic.column_id = c.column_id AND i.is_primary_key = 1) THEN 'PRI'
+		WHEN EXISTS (SELECT 1 FROM sys.index_columns ic JOIN sys.indexes i ON i.object_id = ic.object_id AND i.index_id = ic.index_id
+			WHERE ic.object_id = c.object_id AND ic.column_id = c.column_id AND i.is_unique = 1) THEN 'UNIQUE'
+		ELSE ''
+	END AS [Key],
+	COALESCE(OBJECT_DEFINITION(c.default_object_id), 'NULL') AS [Default],
+	CASE WHEN c.is_identity = 1 THEN 'auto_increment' ELSE '' END AS [Extra]
+	FROM sys.columns c
+WHERE c.object_id = OBJECT_ID(@p1)
+ORDER BY c.column_id
+`
+	qIdentity = `SELECT c.name FROM sys.identity_columns c WHERE c.object_id = OBJECT_ID(@p1)`
+	qInsertColumns = `
+SELECT c.name FROM sys.columns c
+WHERE c.object_id = OBJECT_ID(@p1) AND c.is_computed = 0 AND TYPE_NAME(c.user_type_id) NOT IN ('timestamp','rowversion')
+ORDER BY c.column_id
+`
+)
+
+func objectName(schema,table string) string {
+	if schema=="" { return quote(table) }
+	return quote(schema)+"."+quote(table)
+}
+
+type MssqlSpecialFeatures struct {
+	my2any.SpecialFeatures
+}
+func (p MssqlSpecialFeatures) Perform(db my2any.GenericDB,cmd string,args ...string) (*sql.Rows,error) {
+	switch cmd {
+	case "show.tables":
+		return db.Query(qShowTables)
+	case "show.columns":
+		return db.Query(qShowColumns,objectName("",strings.Trim(args[1],"`")))
+	}
+	return p.SpecialFeatures.Perform(db,cmd,args...)
+}
+
+/*
+Rewrites INSERT statements into tables with an IDENTITY column, so the generated
+ids are returned. OUTPUT ... INTO a table variable is used, as a plain OUTPUT
+clause is not allowed on tables with triggers.
+
+INSERT without a column list gets the list of the table's columns, as T-SQL
+doesn't allow explicit IDENTITY values without one. If the IDENTITY column is
+NULL or 0 in every row, it is left out and the ids are generated, like in MySQL.
+Otherwise explicit values are allowed by SET IDENTITY_INSERT, which is reset,
+if the INSERT fails. REPLACE and ON DUPLICATE KEY UPDATE are not rewritten.
+*/
+func (p MssqlSpecialFeatures) Rewrite(db my2any.GenericDB,ast sqlparser.Statement,pvp *int) (string,bool) {
+	i,ok := ast.(*sqlparser.Insert)
+	if !ok || i.Action==sqlparser.ReplaceStr || len(i.OnDup)!=0 { return "",false }
+	col,columns,pos,err := identity(db,i)
+	if err!=nil { return "",false }
+	rows := i.Rows
+	if vals,ok := rows.(sqlparser.Values); ok && pos>=0 && generated(vals,pos) {
+		columns,rows = dropColumn(columns,vals,pos)
+		pos = -1
+	}
+	explicit := pos>=0
+
+	buf := sqlparser.NewTrackedBuffer(MssqlFormatter)
+	buf.Myprintf("declare @ids table (id bigint);\n")
+	if explicit { buf.Myprintf("begin try\nset identity_insert %v on;\n",i.Table) }
+	buf.Myprintf("insert into %v%v output inserted.%v into @ids %v;\n",
+		i.Table,columns,sqlparser.NewColIdent(col),rows)
+	if explicit {
+		buf.Myprintf("set identity_insert %v off;\nend try\n",i.Table)
+		buf.Myprintf("begin catch\nset identity_insert %v off;\nthrow;\nend catch;\n",i.Table)
+	}
+	buf.Myprintf("select id from @ids order by id")
+	*pvp = my2any.StmtxInsertReturning
+	return buf.String(),true
+}
+
+/*
+Rewrite leaves the IDENTITY column out, if it is NULL or 0 in every row, which
+can't be decided for bind variables. Such INSERTs are not cached.
+*/
+func (p MssqlSpecialFeatures) DependsOnLiterals(db my2any.GenericDB,ast sqlparser.Statement) bool {
+	i,ok := ast.(*sqlparser.Insert)
+	if !ok || i.Action==sqlparser.ReplaceStr || len(i.OnDup)!=0 { return false }
+	vals,ok := i.Rows.(sqlparser.Values)
+	if !ok { return false }
+	_,_,pos,err := identity(db,i)
+	if err==sql.ErrNoRows { return false }
+	if err!=nil { return true }
+	for _,row := range vals {
+		if pos<0 || pos>=len(row) { continue }
+		if v,ok := row[pos].(*sqlparser.SQLVal); ok && v.Type==sqlparser.ValArg { return true }
+	}
+	return false
+}
+
+/*
+Returns the IDENTITY column of the INSERT's table, the columns of the INSERT
+(the table's insertable columns, if it has no column list) and the position of
+the IDENTITY column in them (or -1). Fails with sql.ErrNoRows, if the table has
+no IDENTITY column.
+*/
+func identity(db my2any.GenericDB,i *sqlparser.Insert) (col string,columns sqlparser.Columns,pos int,err error) {
+	table := objectName(i.Table.Qualifier.String(),i.Table.Name.String())
+	if err = db.QueryRow(qIdentity,table).Scan(&col); err!=nil { return }
+	columns = i.Columns
+	if len(columns)==0 {
+		if columns,err = insertColumns(db,table); err!=nil { return }
+	}
+	pos = -1
+	for j,c := range columns {
+		if c.EqualString(col) { pos = j }
+	}
+	return
+}
+
+/* The insertable columns of the table, in the order of INSERT without a column list. */
+func insertColumns(db my2any.GenericDB,table string) (columns sqlparser.Columns,err error) {
+	rs,err := db.Query(qInsertColumns,table)
+	if err!=nil { return }
+	defer rs.Close()
+	for rs.Next() {
+		var name string
+		if err = rs.Scan(&name); err!=nil { return }
+		columns = append(columns,sqlparser.NewColIdent(name))
+	}
+	if err = rs.Err(); err==nil && len(columns)==0 { err = fmt.Errorf("no columns in %s",table) }
+	return
+}
+
+/* Reports, whether the column at pos is NULL or 0 in all rows. */
+func generated(vals sqlparser.Values,pos int) bool {
+	for _,row := range vals {
+		if pos>=len(row) { return false }
+		switch v := row[pos].(type) {
+		case *sqlparser.NullVal:
+		case *sqlparser.SQLVal:
+			if v.Type!=sqlparser.IntVal || strings.Trim(string(v.Val),"0")!="" { return false }
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func dropColumn(columns sqlparser.Columns,vals sqlparser.Values,pos int) (sqlparser.Columns,sqlparser.Values) {
+	nc := append(append(sqlparser.Columns{},columns[:pos]...),columns[pos+1:]...)
+	nv := make(sqlparser.Values,len(vals))
+	for j,row := range vals {
+		nv[j] = append(append(sqlparser.ValTuple{},row[:pos]...),row[pos+1:]...)
+	}
+	return nc,nv
+}
+
+func init() {
+	my2any.Register("mssql",my2any.Dialect{Driver:"sqlserver",Setup:func(g *my2any.Gateway,dsn string) {
+		g.CC = MssqlConverter{g.CC}
+		g.Syn = MssqlSyntaxer{Syntaxer:g.Syn,Formatter:MssqlFormatter}
+		g.SF = MssqlSpecialFeatures{g.SF}
+	}})
+}
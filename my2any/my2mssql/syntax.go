@@ -0,0 +1,199 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2mssql
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+
+func quote(s string) string {
+	return "["+strings.Replace(s,"]","]]",-1)+"]"
+}
+
+func MssqlFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch v := node.(type) {
+	case sqlparser.ColIdent: buf.WriteString(quote(v.String()))
+	case sqlparser.TableIdent: buf.WriteString(quote(v.String()))
+	case *sqlparser.ColIdent: buf.WriteString(quote(v.String()))
+	case *sqlparser.TableIdent: buf.WriteString(quote(v.String()))
+	case *sqlparser.Select:
+		buf.Myprintf("select %v%s",v.Comments,v.Distinct)
+		if v.Limit!=nil && v.Limit.Offset==nil {
+			buf.Myprintf("top (%v) ",v.Limit.Rowcount)
+		}
+		buf.Myprintf("%v",v.SelectExprs)
+		if !my2any.IsDual(v.From) {
+			buf.Myprintf(" from %v",v.From)
+		}
+		buf.Myprintf("%v%v%v%v",v.Where,v.GroupBy,v.Having,v.OrderBy)
+		if v.Limit!=nil && v.Limit.Offset!=nil {
+			/* OFFSET ... FETCH requires an ORDER BY clause. */
+			if len(v.OrderBy)==0 { buf.WriteString(" order by (select null)") }
+			buf.Myprintf(" offset %v rows fetch next %v rows only",v.Limit.Offset,v.Limit.Rowcount)
+		}
+	case *sqlparser.Union:
+		buf.Myprintf("%v %s %v%v",v.Left,v.Type,v.Right,v.OrderBy)
+		if v.Limit!=nil {
+			if len(v.OrderBy)==0 { buf.WriteString(" order by (select null)") }
+			offset := sqlparser.Expr(sqlparser.NewIntVal([]byte("0")))
+			if v.Limit.Offset!=nil { offset = v.Limit.Offset }
+			buf.Myprintf(" offset %v rows fetch next %v rows only",offset,v.Limit.Rowcount)
+		}
+	case *sqlparser.Update:
+		/* T-SQL knows no ORDER BY in UPDATE statements, only TOP (n). */
+		buf.Myprintf("update %v",v.Comments)
+		if v.Limit!=nil { buf.Myprintf("top (%v) ",v.Limit.Rowcount) }
+		buf.Myprintf("%v set %v%v",v.TableExprs,v.Exprs,v.Where)
+	case *sqlparser.Delete:
+		buf.Myprintf("delete %v",v.Comments)
+		if v.Limit!=nil { buf.Myprintf("top (%v) ",v.Limit.Rowcount) }
+		if len(v.Targets)>0 { buf.Myprintf("%v ",v.Targets) }
+		buf.Myprintf("from %v%v",v.TableExprs,v.Where)
+	case sqlparser.BoolVal:
+		if v {
+			buf.WriteString("1")
+		} else {
+			buf.WriteString("0")
+		}
+	case *sqlparser.SQLVal:
+		switch v.Type {
+		case sqlparser.StrVal:
+			/* T-SQL knows no backslash escapes. */
+			buf.WriteString("N'"+strings.Replace(string(v.Val),"'","''",-1)+"'")
+		default:
+			node.Format(buf)
+		}
+	case *sqlparser.IndexDefinition:
+		switch {
+		case v.Info.Primary: buf.WriteString("primary key (")
+		case v.Info.Unique: buf.Myprintf("constraint %v unique (",v.Info.Name)
+		default: buf.Myprintf("index %v (",v.Info.Name)
+		}
+		/* T-SQL knows no index prefix lengths. */
+		for i,c := range v.Columns {
+			if i>0 { buf.WriteString(", ") }
+			buf.Myprintf("%v",c.Column)
+		}
+		buf.WriteString(")")
+	case *sqlparser.GroupConcatExpr:
+		sep := strings.TrimSuffix(strings.TrimPrefix(v.Separator," separator '"),"'")
+		if v.Separator=="" { sep = "," }
+		buf.Myprintf("string_agg(%v, %v)",v.Exprs,sqlparser.NewStrVal([]byte(sep)))
+	case *sqlparser.SubstrExpr:
+		if v.To==nil {
+			buf.Myprintf("substring(%v, %v, 2147483647)",v.Name,v.From)
+		} else {
+			buf.Myprintf("substring(%v, %v, %v)",v.Name,v.From,v.To)
+		}
+	case *sqlparser.FuncExpr:
+		switch strings.ToLower(v.Name.String()) {
+		case "now","current_timestamp","sysdate","localtime","localtimestamp": buf.WriteString("sysdatetime()")
+		case "utc_timestamp": buf.WriteString("sysutcdatetime()")
+		case "curdate","current_date": buf.WriteString("cast(sysdatetime() as date)")
+		case "curtime","current_time": buf.WriteString("cast(sysdatetime() as time)")
+		case "unix_timestamp":
+			if len(v.Exprs)==0 {
+				buf.WriteString("datediff_big(second, '1970-01-01', sysutcdatetime())")
+			} else {
+				buf.Myprintf("datediff_big(second, '1970-01-01', %v)",v.Exprs[0])
+			}
+		case "from_unixtime": buf.Myprintf("dateadd(second, %v, '1970-01-01')",my2any.Arg(v.Exprs,0))
+		case "ifnull": buf.Myprintf("isnull(%v)",v.Exprs)
+		case "if": buf.Myprintf("iif(%v)",v.Exprs)
+		case "length","char_length","character_length": buf.Myprintf("len(%v)",v.Exprs)
+		case "lcase": buf.Myprintf("lower(%v)",v.Exprs)
+		case "ucase": buf.Myprintf("upper(%v)",v.Exprs)
+		case "locate","instr":
+			if strings.ToLower(v.Name.String())=="instr" {
+				buf.Myprintf("charindex(%v, %v)",my2any.Arg(v.Exprs,1),my2any.Arg(v.Exprs,0))
+			} else {
+				buf.Myprintf("charindex(%v)",v.Exprs)
+			}
+		case "last_insert_id": buf.WriteString("scope_identity()")
+		case "database","schema": buf.WriteString("db_name()")
+		case "version": buf.WriteString("@@version")
+		default:
+			node.Format(buf)
+		}
+	default:
+		node.Format(buf)
+	}
+}
+
+/*
+Maps MySQL column types to T-SQL column types.
+*/
+func mapType(ct *sqlparser.ColumnType) {
+	t := strings.ToLower(ct.Type)
+	switch {
+	case t=="bool" || t=="boolean" || (t=="tinyint" && ct.Length!=nil && string(ct.Length.Val)=="1"):
+		ct.Type = "bit"
+	case my2any.IsInteger(t):
+		switch {
+		case t=="bigint": ct.Type = "bigint"
+		case t=="mediumint": ct.Type = "int"
+		case ct.Unsigned && t!="tinyint": ct.Type = "bigint"
+		case t=="tinyint" && !ct.Unsigned: ct.Type = "smallint"
+		}
+	case t=="double" || t=="real": ct.Type = "float"
+	case t=="float": ct.Type = "real"
+	case t=="varchar": ct.Type = "nvarchar"
+	case t=="char": ct.Type = "nchar"
+	case strings.HasSuffix(t,"text") || t=="json" || t=="enum" || t=="set":
+		ct.Type = "nvarchar(max)"
+		ct.Length = nil
+		ct.EnumValues = nil
+	case strings.HasSuffix(t,"blob"):
+		ct.Type = "varbinary(max)"
+		ct.Length = nil
+	case t=="datetime" || t=="timestamp":
+		ct.Type = "datetime2"
+	case t=="year":
+		ct.Type = "smallint"
+	}
+	if my2any.IsInteger(strings.ToLower(ct.Type)) || ct.Type=="bit" { ct.Length = nil }
+	ct.Unsigned = false
+	ct.Zerofill = false
+	ct.Charset = ""
+	ct.Collate = ""
+	ct.Comment = nil
+	ct.OnUpdate = nil
+}
+
+/*
+Translates statements into SQL Server's dialect. The embedded Formatter, which
+implements my2any.Binder, must be MssqlFormatter.
+*/
+type MssqlSyntaxer struct {
+	my2any.Syntaxer
+	my2any.Formatter
+}
+func (MssqlSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
+	ddl,ok := ast.(*sqlparser.DDL)
+	if !ok || ddl.Action!=sqlparser.CreateStr || ddl.TableSpec==nil { return }
+	ddl.TableSpec.Options = ""
+	for _,col := range ddl.TableSpec.Columns {
+		mapType(&col.Type)
+		if !col.Type.Autoincrement { continue }
+		col.Type.Type += " identity(1,1)"
+		col.Type.Autoincrement = false
+	}
+}
+func (MssqlSyntaxer) EncodeAny(ast sqlparser.Statement) string {
+	return my2any.Formatter(MssqlFormatter).Encode(ast)
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2mssql
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "testing"
+
+var syn = MssqlSyntaxer{Syntaxer:my2any.DefaultSyntaxer,Formatter:MssqlFormatter}
+
+func encode(t *testing.T,query string) string {
+	st,err := sqlparser.Parse(query)
+	if err!=nil { t.Fatalf("%s: %v",query,err) }
+	syn.Preprocess(st,"")
+	return syn.EncodeAny(st)
+}
+
+func TestFormatter(t *testing.T) {
+	cases := []struct{
+		query, want string
+	}{
+		{"select a from t limit 10","select top (10) [a] from [t]"},
+		{"select a from t limit 5, 10","select [a] from [t] order by (select null) offset 5 rows fetch next 10 rows only"},
+		{"select now(), 'x'","select sysdatetime(), N'x'"},
+		{"select instr(a, 'x') from t","select charindex(N'x', [a]) from [t]"},
+		{"delete from t limit 3","delete top (3) from [t]"},
+	}
+	for _,c := range cases {
+		if got := encode(t,c.query); got!=c.want { t.Errorf("EncodeAny(%q) = %q; want %q",c.query,got,c.want) }
+	}
+}
+
+func TestEncodeCreate(t *testing.T) {
+	got := encode(t,"create table t (id int unsigned auto_increment, b text, primary key (id))")
+	if !strings.Contains(got,"[id] bigint identity(1,1)") || !strings.Contains(got,"[b] nvarchar(max)") || strings.Contains(got,"auto_increment") {
+		t.Errorf("EncodeAny = %q",got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	lits := []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("it's"))}
+	want := "select [a:v1] from [t] where [b] = N'it''s'"
+	if got := syn.Bind("select [a:v1] from [t] where [b] = :v1",lits); got!=want {
+		t.Errorf("Bind = %q; want %q",got,want)
+	}
+}
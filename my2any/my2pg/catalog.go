@@ -0,0 +1,238 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2pg
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "database/sql"
+import "github.com/lib/pq"
+import "strings"
+import "fmt"
+import "sync"
+import "time"
+
+type Column struct{
+	Name    string
+	Type    string
+	NotNull bool
+	Default sql.NullString
+	Serial  bool /* DEFAULT nextval(...) or identity column */
+	Identity string /* attidentity: "a" (ALWAYS), "d" (BY DEFAULT) or "" */
+	Primary bool
+	Unique  bool
+
+	attnum int64
+}
+
+type Table struct{
+	Schema, Name string
+	Columns []*Column
+	Primary []*Column
+	Unique  [][]*Column
+
+	loaded  time.Time
+	version int
+}
+
+/*
+Returns the auto-increment (serial or identity) column, or nil.
+
+Like in MySQL, only one column is used: A column of the primary key is
+preferred over a column of a unique key, which is preferred over others.
+*/
+func (t *Table) AutoIncrement() *Column {
+	var found *Column
+	rank := 0
+	for _,col := range t.Columns {
+		if !col.Serial { continue }
+		r := 1
+		if col.Unique { r = 2 }
+		if col.Primary { r = 3 }
+		if r>rank { found,rank = col,r }
+	}
+	return found
+}
+
+const (
+	qTableOid = `
+SELECT cls.oid::int8, current_setting('server_version_num')::int
+	FROM pg_catalog.pg_class cls
+	JOIN pg_catalog.pg_namespace nsp ON cls.relnamespace=nsp.oid
+	WHERE nspname = $1 AND relname = $2
+`
+	qColumns = `
+SELECT
+	a.attnum::int8,
+	a.attname::text,
+	pg_catalog.format_type(a.atttypid, a.atttypmod),
+	a.attnotnull,
+	(SELECT pg_catalog.pg_get_expr(b.adbin, b.adrelid) FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) LIMIT 1),
+	%s
+	FROM pg_catalog.pg_attribute a
+WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum
+`
+	qKeys = `
+SELECT contype::text, conkey::int8[]
+	FROM pg_catalog.pg_constraint
+WHERE conrelid = $1 AND contype IN ('p','u')
+`
+)
+
+/*
+Loads the metadata of a table from pg_catalog. Returns nil,nil if the table does not exist.
+*/
+func LoadTable(db my2any.GenericDB,schema,name string) (*Table,error) {
+	var oid int64
+	var version int
+	err := db.QueryRow(qTableOid,schema,name).Scan(&oid,&version)
+	if err==sql.ErrNoRows { return nil,nil }
+	if err!=nil { return nil,err }
+
+	/* Identity columns (pg_attribute.attidentity) exist since PostgreSQL 10. */
+	identity := "''::text"
+	if version>=100000 { identity = "a.attidentity::text" }
+
+	t := &Table{Schema:schema,Name:name,loaded:time.Now(),version:version}
+	byNum := make(map[int64]*Column)
+
+	rs,err := db.Query(fmt.Sprintf(qColumns,identity),oid)
+	if err!=nil { return nil,err }
+	defer rs.Close()
+	for rs.Next() {
+		col := new(Column)
+		err = rs.Scan(&col.attnum,&col.Name,&col.Type,&col.NotNull,&col.Default,&col.Identity)
+		if err!=nil { return nil,err }
+		col.Serial = col.Identity!="" || (col.Default.Valid && strings.HasPrefix(col.Default.String,"nextval"))
+		t.Columns = append(t.Columns,col)
+		byNum[col.attnum] = col
+	}
+	if err = rs.Err(); err!=nil { return nil,err }
+	rs.Close()
+
+	rs,err = db.Query(qKeys,oid)
+	if err!=nil { return nil,err }
+	defer rs.Close()
+	for rs.Next() {
+		var contype string
+		var conkey pq.Int64Array
+		err = rs.Scan(&contype,&conkey)
+		if err!=nil { return nil,err }
+		key := make([]*Column,0,len(conkey))
+		for _,num := range conkey {
+			col := byNum[num]
+			if col==nil { continue }
+			if contype=="p" { col.Primary = true } else { col.Unique = true }
+			key = append(key,col)
+		}
+		if contype=="p" {
+			t.Primary = key
+		} else {
+			t.Unique = append(t.Unique,key)
+		}
+	}
+	return t,rs.Err()
+}
+
+/*
+Per-schema table metadata cache. It is shared by the Rewrite() and Describe()
+methods of PgSpecialFeatures.
+
+Entries are invalidated, if the gateway executes DDL on the table, if they are
+older than TTL (if TTL>0) or on FLUSH TABLES.
+*/
+type Catalog struct{
+	TTL time.Duration
+
+	lock    sync.Mutex
+	schemas map[string]map[string]*Table
+
+	/* server_version_num, 0 if not known yet. */
+	version int
+}
+func NewCatalog(ttl time.Duration) *Catalog {
+	return &Catalog{TTL:ttl,schemas:make(map[string]map[string]*Table)}
+}
+
+func (c *Catalog) get(schema,name string) *Table {
+	c.lock.Lock(); defer c.lock.Unlock()
+	t := c.schemas[schema][name]
+	if t==nil { return nil }
+	if c.TTL>0 && time.Since(t.loaded)>c.TTL { return nil }
+	return t
+}
+func (c *Catalog) put(t *Table) {
+	c.lock.Lock(); defer c.lock.Unlock()
+	m := c.schemas[t.Schema]
+	if m==nil {
+		m = make(map[string]*Table)
+		c.schemas[t.Schema] = m
+	}
+	m[t.Name] = t
+	c.version = t.version
+}
+
+/*
+Returns the metadata of a table, loading it, if it isn't cached yet.
+Returns nil,nil if the table does not exist.
+*/
+func (c *Catalog) Table(db my2any.GenericDB,schema,name string) (*Table,error) {
+	if c==nil { return LoadTable(db,schema,name) }
+	if t := c.get(schema,name); t!=nil { return t,nil }
+	t,err := LoadTable(db,schema,name)
+	if t!=nil && err==nil { c.put(t) }
+	return t,err
+}
+
+/*
+Returns the server_version_num of the backend. It is cached (and taken from
+the loaded tables) until FlushCatalog.
+*/
+func (c *Catalog) Version(db my2any.GenericDB) (version int,err error) {
+	if c!=nil {
+		c.lock.Lock()
+		version = c.version
+		c.lock.Unlock()
+		if version!=0 { return }
+	}
+	err = db.QueryRow(`SELECT current_setting('server_version_num')::int`).Scan(&version)
+	if c!=nil && err==nil {
+		c.lock.Lock()
+		c.version = version
+		c.lock.Unlock()
+	}
+	return
+}
+
+/* Removes a table from the cache. An empty schema matches every schema. */
+func (c *Catalog) InvalidateTable(schema,name string) {
+	if c==nil { return }
+	c.lock.Lock(); defer c.lock.Unlock()
+	if schema!="" {
+		delete(c.schemas[schema],name)
+		return
+	}
+	for _,m := range c.schemas { delete(m,name) }
+}
+
+/* Removes all tables from the cache. */
+func (c *Catalog) FlushCatalog() {
+	if c==nil { return }
+	c.lock.Lock(); defer c.lock.Unlock()
+	c.schemas = make(map[string]map[string]*Table)
+	c.version = 0
+}
@@ -21,12 +21,17 @@ import "github.com/a-mail-group/yoursql/my2any"
 import "database/sql"
 import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
 import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
 import "github.com/lib/pq"
 import "github.com/lib/pq/hstore"
 import "strings"
 import "reflect"
 import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
 import "fmt"
+import "io"
+import "time"
+import "sync/atomic"
 
 import iradix "github.com/hashicorp/go-immutable-radix"
 
@@ -115,6 +120,9 @@ func (p PqConverter) Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interfa
 
 type PgSpecialFeatures struct {
 	my2any.SpecialFeatures
+	
+	/* Table metadata cache. If nil, the metadata is loaded on every use. */
+	Catalog *Catalog
 }
 func (p PgSpecialFeatures) Perform(db my2any.GenericDB,cmd string,args ...string) (*sql.Rows,error) {
 	switch cmd {
@@ -135,12 +143,12 @@ SELECT
 		ELSE ''
 	END::text AS "Key",
 	CASE
-		WHEN 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND adsrc LIKE 'nextval%') THEN 'NULL'
-		WHEN 'd' IN (SELECT 'd'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum )) THEN (SELECT adsrc FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) LIMIT 1)
+		WHEN 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND pg_catalog.pg_get_expr(adbin, adrelid) LIKE 'nextval%') THEN 'NULL'
+		WHEN 'd' IN (SELECT 'd'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum )) THEN (SELECT pg_catalog.pg_get_expr(adbin, adrelid) FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) LIMIT 1)
 		ELSE 'NULL'
 	END::text AS "Default",
 	CASE
-		WHEN 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND adsrc LIKE 'nextval%') THEN 'auto_increment'
+		WHEN 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND pg_catalog.pg_get_expr(adbin, adrelid) LIKE 'nextval%') THEN 'auto_increment'
 		ELSE ''
 	END::text AS "Extra"
 	FROM pg_catalog.pg_attribute a
@@ -157,55 +165,219 @@ AND a.attnum > 0
 	return p.SpecialFeatures.Perform(db,cmd,args...)
 }
 /*
-SELECT
-	a.attname::text AS "InsertID"
-	FROM pg_catalog.pg_attribute a
-WHERE
-a.attrelid = (
-	SELECT cls.oid
-	FROM pg_catalog.pg_class cls
-	JOIN pg_catalog.pg_namespace nsp ON cls.relnamespace=nsp.oid
-	WHERE relname = 'aitest' AND nspname = 'public'
-)
-AND a.attnum > 0
-AND 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND adsrc LIKE 'nextval%')
-AND 'p' IN (SELECT contype FROM pg_catalog.pg_constraint b WHERE (a.attrelid = b.conrelid AND array[a.attnum] <@ b.conkey ))
+Appends a RETURNING clause for the auto-increment column to INSERT statements,
+so the gateway can report the InsertID.
 */
 func (p PgSpecialFeatures) Rewrite(db my2any.GenericDB,ast sqlparser.Statement,pvp *int) (string,bool) {
 	i,ok := ast.(*sqlparser.Insert)
 	if !ok { return "",false }
-	rs,err := db.Query(`
-SELECT
-	a.attname::text AS "InsertID"
-	FROM pg_catalog.pg_attribute a
-WHERE
-a.attrelid = (
-	SELECT cls.oid
-	FROM pg_catalog.pg_class cls
-	JOIN pg_catalog.pg_namespace nsp ON cls.relnamespace=nsp.oid
-	WHERE relname = $1 AND nspname = $2
-)
-AND a.attnum > 0
-AND 'a' IN (SELECT 'a'::char FROM pg_catalog.pg_attrdef b WHERE (a.attrelid = b.adrelid AND a.attnum = b.adnum ) AND adsrc LIKE 'nextval%')
-AND 'p' IN (SELECT contype FROM pg_catalog.pg_constraint b WHERE (a.attrelid = b.conrelid AND array[a.attnum] <@ b.conkey ))
-	`,i.Table.Name.String(),i.Table.Qualifier.String())
-	if err!=nil { return "",false }
-	defer rs.Close()
+	t,err := p.Catalog.Table(db,i.Table.Qualifier.String(),i.Table.Name.String())
+	if err!=nil || t==nil { return "",false }
+	col := t.AutoIncrement()
+	if col==nil { return "",false }
 	buf := sqlparser.NewTrackedBuffer(PgFormatter)
-	buf.Myprintf("%v returning ",ast)
-	comma := false
-	var s string
-	for rs.Next() {
-		rs.Scan(&s)
-		if comma {
-			/*fmt.Fprintf(buf,",%q",s) */
-			break /* XXX: We only use one element and ignore others */
-		} else {
-			fmt.Fprintf(buf,"%q",s)
-			comma = true
-		}
+	if col.Identity=="a" && insertsInto(i,col.Name) {
+		/* MySQL allows explicit values for AUTO_INCREMENT columns. */
+		buf.Myprintf("%s %v%sinto %v%v%v overriding system value %v%v returning ",
+			i.Action, i.Comments, i.Ignore, i.Table, i.Partitions, i.Columns, i.Rows, i.OnDup)
+	} else {
+		buf.Myprintf("%v returning ",ast)
 	}
-	if !comma{ return "",false }
+	fmt.Fprintf(buf,"%q",col.Name)
 	*pvp = my2any.StmtxInsertReturning
 	return buf.String(),true
 }
+func insertsInto(i *sqlparser.Insert,name string) bool {
+	if len(i.Columns)==0 { return true }
+	for _,c := range i.Columns {
+		if c.Equal(sqlparser.NewColIdent(name)) { return true }
+	}
+	return false
+}
+
+/*
+Implements DESCRIBE using the Catalog.
+*/
+func (p PgSpecialFeatures) Describe(db my2any.GenericDB,schema,table string) (*sqltypes.Result,error) {
+	t,err := p.Catalog.Table(db,schema,table)
+	if err!=nil { return nil,err }
+	if t==nil {
+		return nil,mysql.NewSQLError(mysql.ERNoSuchTable,"42S02","Table '%s.%s' doesn't exist",schema,table)
+	}
+	sr := new(sqltypes.Result)
+	sr.Fields = make([]*query.Field,len(describeFields))
+	for i,name := range describeFields {
+		sr.Fields[i] = &query.Field{Name:name,Type:sqlv.Text.Type()}
+	}
+	for _,col := range t.Columns {
+		null,key,def,extra := "YES","","NULL",""
+		if col.NotNull { null = "NO" }
+		if col.Primary {
+			key = "PRI"
+		} else if col.Unique {
+			key = "UNIQUE"
+		}
+		if col.Serial {
+			extra = "auto_increment"
+		} else if col.Default.Valid {
+			def = col.Default.String
+		}
+		row := []string{col.Name,col.Type,null,key,def,extra}
+		vals := make([]sqltypes.Value,len(row))
+		for i,v := range row { vals[i] = sqlv.Text.SQL(v) }
+		sr.Rows = append(sr.Rows,vals)
+		sr.RowsAffected++
+	}
+	return sr,nil
+}
+var describeFields = []string{"Field","Type","Null","Key","Default","Extra"}
+
+/*
+Implements LOAD DATA INFILE with COPY ... FROM STDIN.
+*/
+func (p PgSpecialFeatures) BulkLoad(tx *sql.Tx,table sqlparser.TableName,columns []string,next func() ([]interface{},error)) (n int64,err error) {
+	schema,name := table.Qualifier.String(),table.Name.String()
+	ncols := len(columns)
+	if ncols==0 {
+		t,err := p.Catalog.Table(tx,schema,name)
+		if err!=nil { return 0,err }
+		if t==nil { return 0,mysql.NewSQLError(mysql.ERNoSuchTable,"42S02","Table '%s' doesn't exist",name) }
+		for _,c := range t.Columns { columns = append(columns,c.Name) }
+	}
+	var q string
+	if schema=="" {
+		q = pq.CopyIn(name,columns...)
+	} else {
+		q = pq.CopyInSchema(schema,name,columns...)
+	}
+	stmt,err := tx.Prepare(q)
+	if err!=nil { return 0,err }
+	defer stmt.Close()
+	for {
+		row,err := next()
+		if err==io.EOF { break }
+		if err!=nil { return n,err }
+		if ncols==0 {
+			/* Like MySQL, missing fields are NULL, extra fields are dropped. */
+			nrow := make([]interface{},len(columns))
+			copy(nrow,row)
+			row = nrow
+		}
+		if _,err = stmt.Exec(row...); err!=nil { return n,err }
+		n++
+	}
+	_,err = stmt.Exec()
+	return
+}
+
+/*
+Implements GET_LOCK and friends with session level advisory locks, and LOCK TABLES
+with LOCK TABLE ... IN SHARE MODE (READ) or IN ACCESS EXCLUSIVE MODE (WRITE).
+*/
+func (p PgSpecialFeatures) TryLock(db my2any.GenericDB,key int64) (ok bool,err error) {
+	err = db.QueryRow(`select pg_try_advisory_lock($1)`,key).Scan(&ok)
+	return
+}
+func (p PgSpecialFeatures) Unlock(db my2any.GenericDB,key int64) (ok bool,err error) {
+	err = db.QueryRow(`select pg_advisory_unlock($1)`,key).Scan(&ok)
+	return
+}
+func (p PgSpecialFeatures) UnlockAll(db my2any.GenericDB) error {
+	_,err := db.Exec(`select pg_advisory_unlock_all()`)
+	return err
+}
+func (p PgSpecialFeatures) LockTables(tx *sql.Tx,tables []my2any.TableLock) error {
+	for _,tl := range tables {
+		mode := "share"
+		if tl.Write { mode = "access exclusive" }
+		buf := sqlparser.NewTrackedBuffer(PgFormatter)
+		buf.Myprintf("lock table %v in %s mode",tl.Table,mode)
+		if _,err := tx.Exec(buf.String()); err!=nil { return err }
+	}
+	return nil
+}
+
+/*
+Implements CALL: Functions are called with SELECT * FROM func(...), without
+the arguments of OUT parameters, which are not part of their signature.
+Procedures are called with CALL, which returns the row of INOUT and OUT
+parameters. Procedures return result sets as refcursor parameters.
+*/
+func (p PgSpecialFeatures) Call(tx *sql.Tx,schema string,proc sqlparser.TableName,args sqlparser.SelectExprs) (*sql.Rows,bool,error) {
+	if proc.Qualifier.IsEmpty() && schema!="" { proc.Qualifier = sqlparser.NewTableIdent(schema) }
+	version,err := p.Catalog.Version(tx)
+	if err!=nil { return nil,false,err }
+	
+	/* Procedures (pg_proc.prokind) exist since PostgreSQL 11, before, there are only functions. */
+	kindExpr := "'f'::text"
+	if version>=110000 { kindExpr = "p.prokind::text" }
+	
+	var kind string
+	var modes pq.StringArray
+	err = tx.QueryRow(fmt.Sprintf(`
+SELECT %s, coalesce(p.proargmodes::text[], '{}') FROM pg_catalog.pg_proc p
+	JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = $1 AND p.proname = $2 LIMIT 1
+`,kindExpr),proc.Qualifier.String(),proc.Name.String()).Scan(&kind,&modes)
+	if err==sql.ErrNoRows {
+		return nil,false,mysql.NewSQLError(my2any.ERSpDoesNotExist,"42000","PROCEDURE %s does not exist",sqlparser.String(proc))
+	}
+	if err!=nil { return nil,false,err }
+
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	if kind=="f" {
+		buf.Myprintf("select * from %v(%v)",proc,inArgs(args,modes))
+	} else {
+		buf.Myprintf("call %v(%v)",proc,args)
+	}
+	rs,err := tx.Query(buf.String())
+	return rs,kind!="f",err
+}
+/*
+Drops the arguments of OUT and TABLE parameters (pg_proc.proargmodes, which
+is empty, if all parameters are IN).
+*/
+func inArgs(args sqlparser.SelectExprs,modes []string) sqlparser.SelectExprs {
+	if len(modes)==0 { return args }
+	in := make(sqlparser.SelectExprs,0,len(args))
+	for i,arg := range args {
+		if i<len(modes) && (modes[i]=="o" || modes[i]=="t") { continue }
+		in = append(in,arg)
+	}
+	return in
+}
+func (p PgSpecialFeatures) Cursor(ct *sql.ColumnType) bool {
+	return ct.DatabaseTypeName()=="REFCURSOR"
+}
+func (p PgSpecialFeatures) Fetch(tx *sql.Tx,cursor string) (*sql.Rows,error) {
+	return tx.Query(fmt.Sprintf("fetch all from %q",cursor))
+}
+
+var cursorSeq uint64
+
+/*
+Declares a NO SCROLL cursor for a large SELECT, which is fetched with FETCH FORWARD n.
+*/
+func (p PgSpecialFeatures) DeclareCursor(tx *sql.Tx,query string) (fetch func(n int) (*sql.Rows,error),close func() error,err error) {
+	name := fmt.Sprintf("my2any_cursor_%d",atomic.AddUint64(&cursorSeq,1))
+	if _,err = tx.Exec(fmt.Sprintf("declare %s no scroll cursor for %s",name,query)); err!=nil { return }
+	fetch = func(n int) (*sql.Rows,error) {
+		return tx.Query(fmt.Sprintf("fetch forward %d from %s",n,name))
+	}
+	close = func() error {
+		_,err := tx.Exec("close "+name)
+		return err
+	}
+	return
+}
+
+func (p PgSpecialFeatures) InvalidateTable(schema,name string) { p.Catalog.InvalidateTable(schema,name) }
+func (p PgSpecialFeatures) FlushCatalog() { p.Catalog.FlushCatalog() }
+
+func init() {
+	my2any.Register("postgres",my2any.Dialect{Driver:"postgres",Setup:func(g *my2any.Gateway,dsn string) {
+		g.CC = PqConverter{g.CC}
+		g.Syn = PgSyntaxer{Syntaxer:g.Syn}
+		g.SF = PgSpecialFeatures{SpecialFeatures:g.SF,Catalog:NewCatalog(5*time.Minute)}
+	}})
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2pg
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "testing"
+
+func TestInArgs(t *testing.T) {
+	cases := []struct{
+		args  string
+		modes []string
+		want  string
+	}{
+		{"1, null",nil,"1, null"},
+		{"1, null",[]string{"i","o"},"1"},
+		{"null, 2, null",[]string{"o","b","t"},"2"},
+		{"1, 2, 3",[]string{"i","v"},"1, 2, 3"},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse("select "+c.args)
+		if err!=nil { t.Fatal(err) }
+		got := sqlparser.String(inArgs(st.(*sqlparser.Select).SelectExprs,c.modes))
+		if got!=c.want { t.Errorf("inArgs(%s, %q) = %s, want %s",c.args,c.modes,got,c.want) }
+	}
+}
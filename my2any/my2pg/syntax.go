@@ -52,6 +52,18 @@ func PgFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
 		} else {
 			buf.Myprintf(" offset %v limit %v",v.Offset,v.Rowcount)
 		}
+	case *sqlparser.Update:
+		if multiUpdated(v) {
+			buf.Myprintf("update %v%v set %v from %v%v",v.Comments,v.TableExprs[0],v.Exprs,v.TableExprs[1:],v.Where)
+		} else {
+			node.Format(buf)
+		}
+	case *sqlparser.Delete:
+		if len(v.Targets)==0 && len(v.TableExprs)>1 {
+			buf.Myprintf("delete %vfrom %v using %v%v",v.Comments,v.TableExprs[0],v.TableExprs[1:],v.Where)
+		} else {
+			node.Format(buf)
+		}
 	case *sqlparser.SQLVal:
 		switch v.Type {
 		case sqlparser.ValArg:
@@ -95,11 +107,18 @@ func PgFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
 
 type PgSyntaxer struct {
 	my2any.Syntaxer
+	
+	/*
+	If true, USE INDEX and FORCE INDEX hints are converted into pg_hint_plan
+	comments, otherwise they are dropped.
+	*/
+	HintPlan bool
 }
-func (PgSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
+func (p PgSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
 	if schema!="" {
 		my2any.Qualify(ast,schema)
 	}
+	locksAndHints(ast,p.HintPlan)
 	if ddl,ok := ast.(*sqlparser.DDL); ok {
 		switch ddl.Action {
 		case "create":
@@ -114,7 +133,22 @@ func (PgSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
 			}
 		}
 	}
+	if upd,ok := ast.(*sqlparser.Update); ok {
+		/* An error is reported by Check. */
+		multiUpdate(upd)
+		if upd.Limit!=nil && len(upd.TableExprs)==1 {
+			sel := new(sqlparser.Select)
+			sel.From = upd.TableExprs
+			sel.Where = upd.Where
+			sel.OrderBy = upd.OrderBy
+			sel.Limit = upd.Limit
+			upd.Where = &sqlparser.Where{"where",ctidIn(sel,sqlparser.TableName{})}
+			upd.Limit = nil
+		}
+		upd.OrderBy = nil
+	}
 	if del,ok := ast.(*sqlparser.Delete); ok {
+		multiDelete(del)
 		if del.Limit!=nil {
 			sel := new(sqlparser.Select)
 			sel.From = del.TableExprs
@@ -123,52 +157,363 @@ func (PgSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
 			sel.Limit = del.Limit
 			
 			if len(del.Targets)==0 {
-				sel.SelectExprs = sqlparser.SelectExprs{ &sqlparser.AliasedExpr{
-					Expr:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid")},
-				}}
-				del.Where = &sqlparser.Where{"where",
-					&sqlparser.ComparisonExpr{
-						Operator:"in",
-						Left:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid")},
-						Right:&sqlparser.Subquery{sel},
-					},
-				}
-				del.Limit = nil
-				del.OrderBy = nil
+				del.Where = &sqlparser.Where{"where",ctidIn(sel,sqlparser.TableName{})}
 			} else {
 				crit := make([]sqlparser.Expr,len(del.Targets))
 				for i,targ := range del.Targets {
-					sc := new(sqlparser.Select)
-					*sc = *sel
-					sc.SelectExprs = sqlparser.SelectExprs{ &sqlparser.AliasedExpr{
-						Expr:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid"),Qualifier:targ},
-					}}
-					crit[i] = &sqlparser.ComparisonExpr{
-						Operator:"in",
-						Left:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid"),Qualifier:targ},
-						Right:&sqlparser.Subquery{sc},
-					}
+					crit[i] = ctidIn(sel,targ)
 				}
-				var expr sqlparser.Expr
-				for i,c := range crit {
-					if i==0 {
-						expr = c
-					} else {
-						expr = &sqlparser.AndExpr{expr,c}
-					}
-				}
-				del.Where = &sqlparser.Where{"where",expr}
-				del.Limit = nil
-				del.OrderBy = nil
+				del.Where = &sqlparser.Where{"where",andAll(crit)}
+			}
+			del.Limit = nil
+		}
+		del.OrderBy = nil
+	}
+}
+
+/*
+Translates the locking clauses (LOCK IN SHARE MODE becomes FOR SHARE, FOR UPDATE,
+NOWAIT and SKIP LOCKED are the same in PostgreSQL) and removes the index hints,
+which PostgreSQL doesn't know. With hintPlan, USE INDEX and FORCE INDEX become
+IndexScan hints, which are put into the first comment of the statement, where
+pg_hint_plan expects them. IGNORE INDEX has no equivalent and is dropped.
+*/
+func locksAndHints(ast sqlparser.Statement,hintPlan bool) {
+	var hints []string
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch v := node.(type) {
+		case *sqlparser.Select:
+			v.Lock = forShare(v.Lock)
+		case *sqlparser.Union:
+			v.Lock = forShare(v.Lock)
+		case *sqlparser.AliasedTableExpr:
+			if v.Hints==nil { break }
+			if hintPlan && v.Hints.Type!=sqlparser.IgnoreStr {
+				if h := indexScan(v); h!="" { hints = append(hints,h) }
 			}
+			v.Hints = nil
+		}
+		return true,nil
+	},ast)
+	if len(hints)==0 { return }
+	
+	comment := []byte("/*+ "+strings.Join(hints," ")+" */")
+	for {
+		switch v := ast.(type) {
+		case *sqlparser.Union:
+			ast = v.Left
+			continue
+		case *sqlparser.ParenSelect:
+			ast = v.Select
+			continue
+		case *sqlparser.Select:
+			v.Comments = append(sqlparser.Comments{comment},v.Comments...)
+		case *sqlparser.Update:
+			v.Comments = append(sqlparser.Comments{comment},v.Comments...)
+		case *sqlparser.Delete:
+			v.Comments = append(sqlparser.Comments{comment},v.Comments...)
+		}
+		return
+	}
+}
+func forShare(lock string) string {
+	if !strings.HasPrefix(lock,sqlparser.ShareModeStr) { return lock }
+	return " for share"+lock[len(sqlparser.ShareModeStr):]
+}
+
+/*
+Returns the pg_hint_plan hint for the index hint of the table.
+*/
+func indexScan(t *sqlparser.AliasedTableExpr) string {
+	name := t.As.String()
+	if name=="" {
+		tn,ok := t.Expr.(sqlparser.TableName)
+		if !ok { return "" }
+		name = tn.Name.String()
+	}
+	if len(t.Hints.Indexes)==0 { return "NoIndexScan("+hintQuote(name)+")" }
+	args := []string{hintQuote(name)}
+	for _,idx := range t.Hints.Indexes {
+		args = append(args,hintQuote(idx.String()))
+	}
+	return "IndexScan("+strings.Join(args," ")+")"
+}
+
+/* Quotes a name in a hint like an SQL identifier. */
+func hintQuote(name string) string {
+	return "\""+strings.Replace(name,"\"","\"\"",-1)+"\""
+}
+
+/*
+Creates the expression "ctid in (select ctid from ...)" for the table qual
+(or the only table of the select, if qual is empty). sel is copied.
+*/
+func ctidIn(sel *sqlparser.Select,qual sqlparser.TableName) sqlparser.Expr {
+	sc := new(sqlparser.Select)
+	*sc = *sel
+	sc.SelectExprs = sqlparser.SelectExprs{ &sqlparser.AliasedExpr{
+		Expr:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid"),Qualifier:qual},
+	}}
+	return &sqlparser.ComparisonExpr{
+		Operator:"in",
+		Left:&sqlparser.ColName{Name:sqlparser.NewColIdent("ctid"),Qualifier:qual},
+		Right:&sqlparser.Subquery{sc},
+	}
+}
+
+func andAll(exprs []sqlparser.Expr) sqlparser.Expr {
+	var expr sqlparser.Expr
+	for _,c := range exprs {
+		if _,ok := c.(*sqlparser.OrExpr); ok { c = &sqlparser.ParenExpr{c} }
+		if expr==nil {
+			expr = c
 		} else {
-			del.OrderBy = nil
+			expr = &sqlparser.AndExpr{expr,c}
+		}
+	}
+	return expr
+}
+
+/*
+Flattens inner joins into a list of tables and a list of join conditions.
+Outer joins and joins with USING(...) are kept as they are.
+*/
+func flattenJoins(tes sqlparser.TableExprs) (flat sqlparser.TableExprs,on []sqlparser.Expr) {
+	for _,te := range tes {
+		switch v := te.(type) {
+		case *sqlparser.ParenTableExpr:
+			f,o := flattenJoins(v.Exprs)
+			flat = append(flat,f...)
+			on = append(on,o...)
+			continue
+		case *sqlparser.JoinTableExpr:
+			if v.Join!=sqlparser.JoinStr && v.Join!=sqlparser.StraightJoinStr { break }
+			if len(v.Condition.Using)!=0 { break }
+			f,o := flattenJoins(sqlparser.TableExprs{v.LeftExpr,v.RightExpr})
+			flat = append(flat,f...)
+			on = append(on,o...)
+			if v.Condition.On!=nil { on = append(on,v.Condition.On) }
+			continue
 		}
+		flat = append(flat,te)
 	}
+	return
+}
+
+/* Reports, whether te is the table (or alias) tn. */
+func isTable(te sqlparser.TableExpr,tn sqlparser.TableName) bool {
+	ate,ok := te.(*sqlparser.AliasedTableExpr)
+	if !ok { return false }
+	if tn.Qualifier.IsEmpty() && !ate.As.IsEmpty() { return ate.As.String()==tn.Name.String() }
+	etn,ok := ate.Expr.(sqlparser.TableName)
+	if !ok || etn.Name.String()!=tn.Name.String() { return false }
+	return tn.Qualifier.IsEmpty() || etn.Qualifier.String()==tn.Qualifier.String()
+}
+
+/*
+Moves the table at index i to the front of the list, the other tables become
+the FROM/USING list. The join conditions are added to the where clause.
+*/
+func targetFirst(flat sqlparser.TableExprs,on []sqlparser.Expr,i int,where *sqlparser.Where) (sqlparser.TableExprs,*sqlparser.Where) {
+	tabs := sqlparser.TableExprs{flat[i]}
+	tabs = append(tabs,flat[:i]...)
+	tabs = append(tabs,flat[i+1:]...)
+	if len(on)==0 { return tabs,where }
+	if where!=nil { on = append(on,where.Expr) }
+	return tabs,&sqlparser.Where{"where",andAll(on)}
 }
+
+var errMultiUpdate = fmt.Errorf("multi-table UPDATE of several tables is not supported")
+
+/*
+Translates UPDATE a JOIN b ON c SET a.x = b.y WHERE w
+into        UPDATE a SET x = b.y FROM b WHERE c AND w
+
+The updated table is derived from the SET clause, PostgreSQL can only update one
+table. If the SET clause refers to several tables, the statement is left as it
+is and errMultiUpdate is returned.
+*/
+func multiUpdate(upd *sqlparser.Update) error {
+	if len(upd.TableExprs)==1 {
+		if _,ok := upd.TableExprs[0].(*sqlparser.AliasedTableExpr); ok { return nil }
+	}
+	flat,on := flattenJoins(upd.TableExprs)
+	var target sqlparser.TableName
+	for _,ue := range upd.Exprs {
+		q := ue.Name.Qualifier
+		if q.IsEmpty() { continue }
+		if target.IsEmpty() {
+			target = q
+		} else if target!=q {
+			return errMultiUpdate
+		}
+	}
+	i := 0
+	if !target.IsEmpty() {
+		for i = 0; i<len(flat); i++ {
+			if isTable(flat[i],target) { break }
+		}
+		/* An unknown table, the backend reports it. */
+		if i==len(flat) { return nil }
+	} else if _,ok := flat[0].(*sqlparser.AliasedTableExpr); !ok {
+		return nil
+	}
+	for _,ue := range upd.Exprs {
+		ue.Name.Qualifier = sqlparser.TableName{}
+	}
+	upd.TableExprs,upd.Where = targetFirst(flat,on,i,upd.Where)
+	return nil
+}
+
+/*
+Reports, whether multiUpdate has translated the statement: It has several tables,
+the first one is updated, and the SET clause is unqualified.
+*/
+func multiUpdated(upd *sqlparser.Update) bool {
+	if len(upd.TableExprs)<2 { return false }
+	for _,ue := range upd.Exprs {
+		if !ue.Name.Qualifier.IsEmpty() { return false }
+	}
+	return true
+}
+
+/*
+Implements my2any.Checker: Fails for the statements, that Preprocess can't
+translate.
+*/
+func (PgSyntaxer) Check(ast sqlparser.Statement) error {
+	if upd,ok := ast.(*sqlparser.Update); ok { return multiUpdate(upd) }
+	return nil
+}
+
+/*
+Translates DELETE a FROM a JOIN b ON c WHERE w
+into        DELETE FROM a USING b WHERE c AND w
+*/
+func multiDelete(del *sqlparser.Delete) {
+	if len(del.Targets)!=1 { return }
+	flat,on := flattenJoins(del.TableExprs)
+	for i,te := range flat {
+		if !isTable(te,del.Targets[0]) { continue }
+		del.Targets = nil
+		del.TableExprs,del.Where = targetFirst(flat,on,i,del.Where)
+		return
+	}
+}
+
 func (PgSyntaxer) EncodeAny(ast sqlparser.Statement) string {
 	buf := sqlparser.NewTrackedBuffer(PgFormatter)
 	buf.Myprintf("%v",ast)
 	return buf.String()
 }
 
+
+/*
+Substitutes the literals into a statement translated with the bind variables :v1, :v2, ...
+(which PgFormatter encodes as $1, $2, ...).
+*/
+func (PgSyntaxer) Bind(tmpl string, lits []*sqlparser.SQLVal) string {
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	for i := 0; i<len(tmpl); i++ {
+		ch := tmpl[i]
+		switch {
+		case ch=='\'' || ch=='"':
+			j := i+1
+			for ; j<len(tmpl); j++ {
+				if tmpl[j]=='\\' && ch=='\'' { j++; continue }
+				if tmpl[j]==ch { break }
+			}
+			if j>=len(tmpl) { j = len(tmpl)-1 }
+			buf.WriteString(tmpl[i:j+1])
+			i = j
+		case ch=='$' && i+1<len(tmpl) && '0'<=tmpl[i+1] && tmpl[i+1]<='9':
+			n := 0
+			j := i+1
+			for ; j<len(tmpl) && '0'<=tmpl[j] && tmpl[j]<='9'; j++ {
+				n = n*10 + int(tmpl[j]-'0')
+			}
+			if n<1 || n>len(lits) {
+				buf.WriteString(tmpl[i:j])
+			} else {
+				buf.Myprintf("%v",lits[n-1])
+			}
+			i = j-1
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+/*
+Implements my2any.ObjectSyntaxer: Views and triggers.
+*/
+func (PgSyntaxer) EncodeView(v *my2any.View) (string,error) {
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	buf.WriteString("create ")
+	if v.Replace { buf.WriteString("or replace ") }
+	buf.Myprintf("view %v%v as %v",v.Name,v.Columns,v.Select)
+	if v.CheckOption!="" { buf.Myprintf(" with %s check option",v.CheckOption) }
+	return buf.String(),nil
+}
+func (PgSyntaxer) EncodeDropView(names []sqlparser.TableName, ifExists bool) (string,error) {
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	buf.WriteString("drop view ")
+	if ifExists { buf.WriteString("if exists ") }
+	for i,tn := range names {
+		if i>0 { buf.WriteString(", ") }
+		buf.Myprintf("%v",tn)
+	}
+	return buf.String(),nil
+}
+
+/*
+The function of the trigger, named "<trigger>_trigger".
+*/
+func triggerFunc(name sqlparser.TableName) sqlparser.TableName {
+	name.Name = sqlparser.NewTableIdent(name.Name.String()+"_trigger")
+	return name
+}
+
+/*
+A trigger becomes a PL/pgSQL trigger function (see triggerFunc) and the trigger,
+that executes it.
+*/
+func (PgSyntaxer) EncodeTrigger(t *my2any.Trigger) (string,error) {
+	fn := triggerFunc(t.Name)
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	buf.Myprintf("create function %v() returns trigger language plpgsql as $trigger$\nbegin\n",fn)
+	for _,s := range t.Body {
+		if s.Stmt!=nil {
+			buf.Myprintf("\t%v;\n",s.Stmt)
+			continue
+		}
+		for _,ue := range s.Set {
+			buf.Myprintf("\t%v := %v;\n",ue.Name,ue.Expr)
+		}
+	}
+	/* The result of AFTER triggers is ignored, BEFORE triggers return the row. */
+	ret := "null"
+	if t.Timing=="before" {
+		ret = "new"
+		if t.Event=="delete" { ret = "old" }
+	}
+	buf.Myprintf("\treturn %s;\nend\n$trigger$;\n",ret)
+	buf.Myprintf("create trigger %v %s %s on %v for each row execute procedure %v()",t.Name.Name,t.Timing,t.Event,t.Table,fn)
+	s := buf.String()
+	if strings.Count(s,"$trigger$")!=2 {
+		return "",fmt.Errorf("CREATE TRIGGER: the body must not contain $trigger$")
+	}
+	return s,nil
+}
+
+/*
+Dropping the trigger function drops the trigger as well.
+*/
+func (PgSyntaxer) EncodeDropTrigger(name sqlparser.TableName, ifExists bool) (string,error) {
+	buf := sqlparser.NewTrackedBuffer(PgFormatter)
+	buf.WriteString("drop function ")
+	if ifExists { buf.WriteString("if exists ") }
+	buf.Myprintf("%v() cascade",triggerFunc(name))
+	return buf.String(),nil
+}
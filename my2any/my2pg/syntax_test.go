@@ -0,0 +1,201 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2pg
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "reflect"
+import "testing"
+
+func strs(tes sqlparser.TableExprs) (s []string) {
+	for _,te := range tes { s = append(s,sqlparser.String(te)) }
+	return
+}
+func whereStr(w *sqlparser.Where) string {
+	if w==nil { return "" }
+	return sqlparser.String(w.Expr)
+}
+
+func TestFlattenJoins(t *testing.T) {
+	cases := []struct{
+		query string
+		flat, on []string
+	}{
+		{"select * from a join b on a.x = b.x straight_join c on b.y = c.y",[]string{"a","b","c"},[]string{"a.x = b.x","b.y = c.y"}},
+		{"select * from (a join b on a.x = b.x), c",[]string{"a","b","c"},[]string{"a.x = b.x"}},
+		{"select * from a join b using (id)",[]string{"a join b using (id)"},nil},
+		{"select * from a left join b on a.x = b.x",[]string{"a left join b on a.x = b.x"},nil},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse(c.query)
+		if err!=nil { t.Fatalf("%s: %v",c.query,err) }
+		flat,on := flattenJoins(st.(*sqlparser.Select).From)
+		var ons []string
+		for _,e := range on { ons = append(ons,sqlparser.String(e)) }
+		if !reflect.DeepEqual(strs(flat),c.flat) || !reflect.DeepEqual(ons,c.on) {
+			t.Errorf("flattenJoins(%q) = %q, %q; want %q, %q",c.query,strs(flat),ons,c.flat,c.on)
+		}
+	}
+}
+
+func TestMultiUpdate(t *testing.T) {
+	cases := []struct{
+		query string
+		tables []string
+		set, where string
+		fails bool
+	}{
+		{"update a join b on a.id = b.id set a.x = b.y where b.z = 1",[]string{"a","b"},"x = b.y","a.id = b.id and b.z = 1",false},
+		{"update a join b on a.id = b.id set b.y = a.x",[]string{"b","a"},"y = a.x","a.id = b.id",false},
+		{"update t1 as x join t2 as y on x.id = y.id set x.v = y.v",[]string{"t1 as x","t2 as y"},"v = y.v","x.id = y.id",false},
+		{"update a, b set a.x = b.y where a.id = b.id",[]string{"a","b"},"x = b.y","a.id = b.id",false},
+		{"update a join b on a.id = b.id set b.y = 1 where a.z = 1 or a.z = 2",[]string{"b","a"},"y = 1","a.id = b.id and (a.z = 1 or a.z = 2)",false},
+		/* Unchanged: */
+		{"update a set x = 1",[]string{"a"},"x = 1","",false},
+		{"update a join b on a.id = b.id set a.x = 1, b.y = 2",[]string{"a join b on a.id = b.id"},"a.x = 1, b.y = 2","",true},
+		{"update a, b set a.x = 1, b.y = 2",[]string{"a","b"},"a.x = 1, b.y = 2","",true},
+		{"update a left join b on a.id = b.id set a.x = b.y",[]string{"a left join b on a.id = b.id"},"a.x = b.y","",false},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse(c.query)
+		if err!=nil { t.Fatalf("%s: %v",c.query,err) }
+		upd := st.(*sqlparser.Update)
+		err = multiUpdate(upd)
+		tables,set,where := strs(upd.TableExprs),sqlparser.String(upd.Exprs),whereStr(upd.Where)
+		if !reflect.DeepEqual(tables,c.tables) || set!=c.set || where!=c.where || (err!=nil)!=c.fails {
+			t.Errorf("multiUpdate(%q): tables %q, set %q, where %q, %v; want %q, %q, %q, fails %v",c.query,tables,set,where,err,c.tables,c.set,c.where,c.fails)
+		}
+		if err==nil && multiUpdated(upd)!=(len(c.tables)>1) {
+			t.Errorf("multiUpdated(%q) = %v",c.query,!(len(c.tables)>1))
+		}
+	}
+}
+
+func TestMultiDelete(t *testing.T) {
+	cases := []struct{
+		query string
+		targets int
+		tables []string
+		where string
+	}{
+		{"delete a from a join b on a.id = b.id where b.z = 1",0,[]string{"a","b"},"a.id = b.id and b.z = 1"},
+		{"delete b from a join b on a.id = b.id",0,[]string{"b","a"},"a.id = b.id"},
+		{"delete x from t1 as x join t2 as y on x.id = y.id",0,[]string{"t1 as x","t2 as y"},"x.id = y.id"},
+		{"delete a from a join b on a.id = b.id where b.z = 1 or b.z = 2",0,[]string{"a","b"},"a.id = b.id and (b.z = 1 or b.z = 2)"},
+		/* Unchanged: */
+		{"delete a, b from a join b on a.id = b.id",2,[]string{"a join b on a.id = b.id"},""},
+		{"delete c from a join b on a.id = b.id",1,[]string{"a join b on a.id = b.id"},""},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse(c.query)
+		if err!=nil { t.Fatalf("%s: %v",c.query,err) }
+		del := st.(*sqlparser.Delete)
+		multiDelete(del)
+		tables,where := strs(del.TableExprs),whereStr(del.Where)
+		if len(del.Targets)!=c.targets || !reflect.DeepEqual(tables,c.tables) || where!=c.where {
+			t.Errorf("multiDelete(%q): %d targets, tables %q, where %q; want %d, %q, %q",c.query,len(del.Targets),tables,where,c.targets,c.tables,c.where)
+		}
+	}
+}
+
+func TestLocksAndHints(t *testing.T) {
+	cases := []struct{
+		query, lock string
+		hintPlan bool
+		want string
+	}{
+		{"select * from t"," lock in share mode",false,"select * from t for share"},
+		{"select * from t"," for update nowait",false,"select * from t for update nowait"},
+		{"select a from t union select a from u"," lock in share mode skip locked",false,"select a from t union select a from u for share skip locked"},
+		{"select * from t force index (i1, i2) where a = 1","",false,"select * from t where a = 1"},
+		{"select * from t force index (i1, i2) where a = 1","",true,`select /*+ IndexScan("t" "i1" "i2") */ * from t where a = 1`},
+		{"select * from t as x use index (i) join u ignore index (j) on x.id = u.id","",true,`select /*+ IndexScan("x" "i") */ * from t as x join u on x.id = u.id`},
+		{"select a from t force index (i) union select a from u","",true,`select /*+ IndexScan("t" "i") */ a from t union select a from u`},
+		{"select * from t force index (`a\"b`)","",true,`select /*+ IndexScan("t" "a""b") */ * from t`},
+	}
+	for _,c := range cases {
+		st := parse(t,c.query)
+		/* As my2any's parser stores the locking clauses, which vitess doesn't know. */
+		if c.lock!="" {
+			switch v := st.(type) {
+			case *sqlparser.Select: v.Lock = c.lock
+			case *sqlparser.Union: v.Lock = c.lock
+			}
+		}
+		locksAndHints(st,c.hintPlan)
+		if got := sqlparser.String(st); got!=c.want {
+			t.Errorf("locksAndHints(%q, %q, %v) = %q, want %q",c.query,c.lock,c.hintPlan,got,c.want)
+		}
+	}
+}
+
+func parse(t *testing.T,query string) sqlparser.Statement {
+	st,err := sqlparser.Parse(query)
+	if err!=nil { t.Fatalf("%s: %v",query,err) }
+	return st
+}
+
+func TestEncodeView(t *testing.T) {
+	name := sqlparser.TableName{Qualifier:sqlparser.NewTableIdent("s"),Name:sqlparser.NewTableIdent("v")}
+	sel := parse(t,"select a, b from t where x > 1").(sqlparser.SelectStatement)
+	cases := []struct{
+		view *my2any.View
+		want string
+	}{
+		{&my2any.View{Name:name,Select:sel},`create view "s"."v" as select "a", "b" from "t" where "x" > 1`},
+		{&my2any.View{Replace:true,Name:name,Columns:sqlparser.Columns{sqlparser.NewColIdent("a"),sqlparser.NewColIdent("b")},Select:sel,CheckOption:"local"},
+			`create or replace view "s"."v"("a", "b") as select "a", "b" from "t" where "x" > 1 with local check option`},
+	}
+	for _,c := range cases {
+		got,err := PgSyntaxer{}.EncodeView(c.view)
+		if err!=nil || got!=c.want { t.Errorf("EncodeView = %q, %v; want %q",got,err,c.want) }
+	}
+}
+
+func TestEncodeTrigger(t *testing.T) {
+	name := sqlparser.TableName{Qualifier:sqlparser.NewTableIdent("s"),Name:sqlparser.NewTableIdent("tr")}
+	table := sqlparser.TableName{Qualifier:sqlparser.NewTableIdent("s"),Name:sqlparser.NewTableIdent("t")}
+	set := my2any.TriggerStmt{Set:parse(t,"update t set new.a = 1").(*sqlparser.Update).Exprs}
+	ins := my2any.TriggerStmt{Stmt:parse(t,"insert into s.log(x) values (new.a)")}
+	cases := []struct{
+		timing, event string
+		body []my2any.TriggerStmt
+		want string
+	}{
+		{"before","insert",[]my2any.TriggerStmt{set,ins},
+			"create function \"s\".\"tr_trigger\"() returns trigger language plpgsql as $trigger$\nbegin\n"+
+			"\t\"new\".\"a\" := 1;\n\tinsert into \"s\".\"log\"(\"x\") values (\"new\".\"a\");\n"+
+			"\treturn new;\nend\n$trigger$;\n"+
+			"create trigger \"tr\" before insert on \"s\".\"t\" for each row execute procedure \"s\".\"tr_trigger\"()"},
+		{"after","update",[]my2any.TriggerStmt{ins},
+			"create function \"s\".\"tr_trigger\"() returns trigger language plpgsql as $trigger$\nbegin\n"+
+			"\tinsert into \"s\".\"log\"(\"x\") values (\"new\".\"a\");\n"+
+			"\treturn null;\nend\n$trigger$;\n"+
+			"create trigger \"tr\" after update on \"s\".\"t\" for each row execute procedure \"s\".\"tr_trigger\"()"},
+	}
+	for _,c := range cases {
+		got,err := PgSyntaxer{}.EncodeTrigger(&my2any.Trigger{Name:name,Timing:c.timing,Event:c.event,Table:table,Body:c.body})
+		if err!=nil || got!=c.want { t.Errorf("EncodeTrigger(%s %s) = %q, %v; want %q",c.timing,c.event,got,err,c.want) }
+	}
+	
+	/* The body can't end the dollar quoting. */
+	bad := my2any.TriggerStmt{Stmt:parse(t,"insert into log values ('$trigger$')")}
+	if _,err := (PgSyntaxer{}).EncodeTrigger(&my2any.Trigger{Name:name,Timing:"after",Event:"insert",Table:table,Body:[]my2any.TriggerStmt{bad}}); err==nil {
+		t.Errorf("EncodeTrigger: no error for $trigger$ in the body")
+	}
+}
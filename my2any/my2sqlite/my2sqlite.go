@@ -0,0 +1,127 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+SQLite dialect for my2any.
+
+The package is independent of the SQLite driver, open the *sql.DB with
+github.com/mattn/go-sqlite3 or any other driver.
+*/
+package my2sqlite
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "database/sql"
+import sqlv "gopkg.in/src-d/go-mysql-server.v0/sql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "reflect"
+import "strings"
+import "time"
+import "fmt"
+
+// HACK! Rename this type so it doesn't clashes with method name!
+type ntype sqlv.Type
+
+/*
+SQLite is dynamically typed: A column with INTEGER affinity may still contain
+text. Values, that don't fit the column type are sent as text.
+*/
+type dyntype struct {
+	ntype
+	kind reflect.Kind
+}
+func (d dyntype) SQL(i interface{}) sqltypes.Value {
+	if i==nil { return sqltypes.NULL }
+	v := reflect.ValueOf(i)
+	if v.Kind()==d.kind { return d.ntype.SQL(i) }
+	if d.kind==reflect.Struct {
+		if _,ok := i.(time.Time); ok { return d.ntype.SQL(i) }
+	}
+	switch b := i.(type) {
+	case []byte: return sqltypes.MakeTrusted(d.Type(),b)
+	}
+	return sqltypes.MakeTrusted(d.Type(),[]byte(fmt.Sprint(i)))
+}
+
+/*
+Maps the declared type of a column to a type, following SQLite's rules for
+type affinity.
+*/
+func affinity(decl string) (sqlv.Type,reflect.Kind) {
+	d := strings.ToUpper(decl)
+	switch {
+	case strings.Contains(d,"INT"): return sqlv.Int64,reflect.Int64
+	case strings.Contains(d,"CHAR"),strings.Contains(d,"CLOB"),strings.Contains(d,"TEXT"): return sqlv.Text,reflect.String
+	case strings.Contains(d,"BLOB"): return sqlv.Blob,reflect.Slice
+	case strings.Contains(d,"REAL"),strings.Contains(d,"FLOA"),strings.Contains(d,"DOUB"): return sqlv.Float64,reflect.Float64
+	case strings.HasPrefix(d,"BOOL"): return sqlv.Boolean,reflect.Bool
+	case d=="DATE": return sqlv.Date,reflect.Struct
+	case strings.Contains(d,"DATE"),strings.Contains(d,"TIME"): return sqlv.Timestamp,reflect.Struct
+	}
+	return sqlv.Text,reflect.String
+}
+
+type SqliteConverter struct {
+	my2any.Converter
+}
+func (p SqliteConverter) Convert(nct *sql.ColumnType) (col *sqlv.Column,scan interface{}) {
+	t,k := affinity(nct.DatabaseTypeName())
+	col = &sqlv.Column{Name:nct.Name(),Type:dyntype{t,k}}
+	scan = new(interface{})
+	return
+}
+
+const (
+	qShowTables = `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	qShowColumns = `
+SELECT
+	name AS "Field",
+	type AS "Type",
+	CASE WHEN "notnull" THEN 'NO' ELSE 'YES' END AS "Null",
+	CASE WHEN pk > 0 THEN 'PRI' ELSE '' END AS "Key",
+	COALESCE(dflt_value,'NULL') AS "Default",
+	CASE
+		WHEN pk = 1 AND upper(type) = 'INTEGER' AND (SELECT count(*) FROM pragma_table_info(?1) WHERE pk > 0) = 1 THEN 'auto_increment'
+		ELSE ''
+	END AS "Extra"
+	FROM pragma_table_info(?1)
+ORDER BY cid
+`
+)
+
+type SqliteSpecialFeatures struct {
+	my2any.SpecialFeatures
+}
+func (p SqliteSpecialFeatures) Perform(db my2any.GenericDB,cmd string,args ...string) (*sql.Rows,error) {
+	switch cmd {
+	case "show.tables":
+		return db.Query(qShowTables)
+	case "show.columns":
+		return db.Query(qShowColumns,strings.Trim(args[1],"`"))
+	}
+	return p.SpecialFeatures.Perform(db,cmd,args...)
+}
+
+func init() {
+	my2any.Register("sqlite",my2any.Dialect{Driver:"sqlite3",Setup:func(g *my2any.Gateway,dsn string) {
+		/* Every connection to an in-memory database is a separate database. */
+		if strings.Contains(dsn,":memory:") || strings.Contains(dsn,"mode=memory") {
+			g.DB.SetMaxOpenConns(1)
+		}
+		g.CC = SqliteConverter{g.CC}
+		g.Syn = SqliteSyntaxer{Syntaxer:g.Syn,Formatter:SqliteFormatter}
+		g.SF = SqliteSpecialFeatures{g.SF}
+	}})
+}
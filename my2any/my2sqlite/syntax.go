@@ -0,0 +1,180 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2sqlite
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+
+func concat(buf *sqlparser.TrackedBuffer, exprs sqlparser.SelectExprs) {
+	if len(exprs)==0 { buf.WriteString("NULL"); return }
+	buf.WriteString("(")
+	for i,se := range exprs {
+		if i==0 {
+			buf.Myprintf("%v",se)
+		} else {
+			buf.Myprintf(" || %v",se)
+		}
+	}
+	buf.WriteString(")")
+}
+
+func SqliteFormatter (buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch v := node.(type) {
+	case *sqlparser.Limit:
+		if v==nil || v.Offset==nil {
+			node.Format(buf)
+		} else {
+			buf.Myprintf(" limit %v offset %v",v.Rowcount,v.Offset)
+		}
+	case *sqlparser.SQLVal:
+		switch v.Type {
+		case sqlparser.StrVal:
+			/* SQLite knows no backslash escapes. */
+			buf.WriteString("'"+strings.Replace(string(v.Val),"'","''",-1)+"'")
+		default:
+			node.Format(buf)
+		}
+	case *sqlparser.Insert:
+		if v.Ignore!="" {
+			nv := *v
+			nv.Ignore = "or ignore "
+			nv.Format(buf)
+		} else {
+			node.Format(buf)
+		}
+	case *sqlparser.ColumnDefinition:
+		if v.Type.Autoincrement {
+			buf.Myprintf("%v integer primary key autoincrement",v.Name)
+		} else {
+			node.Format(buf)
+		}
+	case *sqlparser.GroupConcatExpr:
+		/* SQLite knows no ORDER BY in group_concat(), the separator is the second argument. */
+		buf.Myprintf("group_concat(%s%v",v.Distinct,v.Exprs)
+		if sep := v.Separator; sep!="" {
+			sep = strings.TrimSuffix(strings.TrimPrefix(sep," separator '"),"'")
+			buf.Myprintf(", %v",sqlparser.NewStrVal([]byte(sep)))
+		}
+		buf.WriteString(")")
+	case *sqlparser.FuncExpr:
+		switch strings.ToLower(v.Name.String()) {
+		case "now","current_timestamp","sysdate","localtime","localtimestamp": buf.WriteString("datetime('now')")
+		case "curdate","current_date": buf.WriteString("date('now')")
+		case "curtime","current_time": buf.WriteString("time('now')")
+		case "utc_timestamp": buf.WriteString("datetime('now')")
+		case "unix_timestamp":
+			if len(v.Exprs)==0 {
+				buf.WriteString("cast(strftime('%s','now') as integer)")
+			} else {
+				buf.WriteString("cast(strftime('%s',")
+				buf.Myprintf("%v",v.Exprs[0])
+				buf.WriteString(") as integer)")
+			}
+		case "from_unixtime": buf.Myprintf("datetime(%v,'unixepoch')",my2any.Arg(v.Exprs,0))
+		case "concat": concat(buf,v.Exprs)
+		case "if": buf.Myprintf("(case when %v then %v else %v end)",my2any.Arg(v.Exprs,0),my2any.Arg(v.Exprs,1),my2any.Arg(v.Exprs,2))
+		case "char_length","character_length": buf.Myprintf("length(%v)",v.Exprs)
+		case "lcase": buf.Myprintf("lower(%v)",v.Exprs)
+		case "ucase": buf.Myprintf("upper(%v)",v.Exprs)
+		case "left": buf.Myprintf("substr(%v, 1, %v)",my2any.Arg(v.Exprs,0),my2any.Arg(v.Exprs,1))
+		case "right": buf.Myprintf("substr(%v, -(%v))",my2any.Arg(v.Exprs,0),my2any.Arg(v.Exprs,1))
+		case "locate": buf.Myprintf("instr(%v, %v)",my2any.Arg(v.Exprs,1),my2any.Arg(v.Exprs,0))
+		case "rand": buf.WriteString("(abs(random()) / 9223372036854775807.0)")
+		case "last_insert_id": buf.WriteString("last_insert_rowid()")
+		case "database","schema": buf.WriteString("'main'")
+		case "version": buf.WriteString("sqlite_version()")
+		default:
+			node.Format(buf)
+		}
+	default:
+		node.Format(buf)
+	}
+}
+
+/*
+The ColumnKeyOption of "col ... PRIMARY KEY". sqlparser declares it as the
+unexported colKeyPrimary, the first constant after colKeyNone.
+*/
+const colKeyPrimary = sqlparser.ColumnKeyOption(1)
+
+/*
+Translates statements into SQLite's dialect. The embedded Formatter, which
+implements my2any.Binder, must be SqliteFormatter.
+*/
+type SqliteSyntaxer struct {
+	my2any.Syntaxer
+	my2any.Formatter
+}
+func (SqliteSyntaxer) Preprocess(ast sqlparser.Statement, schema string) {
+	ddl,ok := ast.(*sqlparser.DDL)
+	if !ok || ddl.Action!=sqlparser.CreateStr || ddl.TableSpec==nil { return }
+	ts := ddl.TableSpec
+	ts.Options = ""
+	for _,col := range ts.Columns {
+		col.Type.Charset = ""
+		col.Type.Collate = ""
+		col.Type.Comment = nil
+		col.Type.OnUpdate = nil
+		switch strings.ToLower(col.Type.Type) {
+		case "enum","set":
+			col.Type.Type = "text"
+			col.Type.EnumValues = nil
+		}
+		if !col.Type.Autoincrement { continue }
+
+		/*
+		AUTOINCREMENT is only allowed on an INTEGER PRIMARY KEY column,
+		so the PRIMARY KEY(col) table constraint is removed. The column
+		is emitted as "integer primary key autoincrement".
+		*/
+		if col.Type.KeyOpt==colKeyPrimary { continue }
+		pk := -1
+		for i,idx := range ts.Indexes {
+			if !idx.Info.Primary { continue }
+			if len(idx.Columns)==1 && idx.Columns[0].Column.Equal(col.Name) { pk = i }
+		}
+		if pk>=0 {
+			ts.Indexes = append(ts.Indexes[:pk],ts.Indexes[pk+1:]...)
+		} else {
+			col.Type.Autoincrement = false
+		}
+	}
+}
+
+/*
+SQLite doesn't support (non-unique) KEY or INDEX clauses in CREATE TABLE,
+so they are emitted as separate CREATE INDEX statements.
+*/
+func encodeCreate(ddl *sqlparser.DDL) string {
+	extra := my2any.SplitIndexes(ddl.TableSpec)
+	buf := sqlparser.NewTrackedBuffer(SqliteFormatter)
+	buf.Myprintf("%v",ddl)
+	for _,idx := range extra {
+		buf.WriteString(";\ncreate index if not exists ")
+		my2any.FormatIndex(buf,ddl.NewName,idx)
+	}
+	return buf.String()
+}
+
+func (SqliteSyntaxer) EncodeAny(ast sqlparser.Statement) string {
+	if ddl,ok := ast.(*sqlparser.DDL); ok && ddl.Action==sqlparser.CreateStr && ddl.TableSpec!=nil {
+		return encodeCreate(ddl)
+	}
+	return my2any.Formatter(SqliteFormatter).Encode(ast)
+}
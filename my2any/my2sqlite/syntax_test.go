@@ -0,0 +1,64 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2sqlite
+
+import "github.com/a-mail-group/yoursql/my2any"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "testing"
+
+var syn = SqliteSyntaxer{Syntaxer:my2any.DefaultSyntaxer,Formatter:SqliteFormatter}
+
+func encode(t *testing.T,query string) string {
+	st,err := sqlparser.Parse(query)
+	if err!=nil { t.Fatalf("%s: %v",query,err) }
+	syn.Preprocess(st,"")
+	return syn.EncodeAny(st)
+}
+
+func TestFormatter(t *testing.T) {
+	cases := []struct{
+		query, want string
+	}{
+		{"select * from t limit 5, 10","select * from t limit 10 offset 5"},
+		{`select 'it\'s' from t`,"select 'it''s' from t"},
+		{"select concat(a, b), ucase(a), locate('x', a) from t","select (a || b), upper(a), instr(a, 'x') from t"},
+		{"insert ignore into t(a) values (1)","insert or ignore into t(a) values (1)"},
+	}
+	for _,c := range cases {
+		if got := encode(t,c.query); got!=c.want { t.Errorf("EncodeAny(%q) = %q; want %q",c.query,got,c.want) }
+	}
+}
+
+func TestEncodeCreate(t *testing.T) {
+	got := encode(t,"create table t (id int auto_increment, b int, primary key (id), key k (b), fulltext key f (b))")
+	if !strings.Contains(got,"id integer primary key autoincrement") || strings.Contains(got,"primary key (id)") {
+		t.Errorf("AUTO_INCREMENT column: %q",got)
+	}
+	if strings.Contains(got,"key k") || strings.Contains(got,"fulltext") || !strings.HasSuffix(got,";\ncreate index if not exists t_k on t (b)") {
+		t.Errorf("indexes: %q",got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	lits := []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("it's")),sqlparser.NewIntVal([]byte("2"))}
+	want := "select 'it''s' from t where ':v1' = a limit 2"
+	if got := syn.Bind("select :v1 from t where ':v1' = a limit :v2",lits); got!=want {
+		t.Errorf("Bind = %q; want %q",got,want)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "bytes"
+import "fmt"
+
+func isSpace(ch byte) bool { return ch==' ' || ch=='\t' || ch=='\n' || ch=='\r' }
+func isDigit(ch byte) bool { return '0'<=ch && ch<='9' }
+func isHex(ch byte) bool { return isDigit(ch) || ('a'<=ch && ch<='f') || ('A'<=ch && ch<='F') }
+func isIdent(ch byte) bool {
+	return ('a'<=ch && ch<='z') || ('A'<=ch && ch<='Z') || isDigit(ch) || ch=='_' || ch=='$' || ch>=0x80
+}
+
+/* Returns the index after the closing quote. */
+func scanQuoted(q string,i int) int {
+	quote := q[i]
+	for i++; i<len(q); i++ {
+		switch q[i] {
+		case '\\':
+			if quote!='`' { i++ }
+		case quote:
+			if i+1<len(q) && q[i+1]==quote { i++; continue }
+			return i+1
+		}
+	}
+	return -1
+}
+
+/* Returns the index after the numeric literal starting at i. */
+func scanNumber(q string,i int) int {
+	if q[i]=='0' && i+2<len(q) && (q[i+1]=='x' || q[i+1]=='X') && isHex(q[i+2]) {
+		for i += 2; i<len(q) && isHex(q[i]); i++ {}
+		return i
+	}
+	for ; i<len(q) && isDigit(q[i]); i++ {}
+	if i<len(q) && q[i]=='.' {
+		for i++; i<len(q) && isDigit(q[i]); i++ {}
+	}
+	if i+1<len(q) && (q[i]=='e' || q[i]=='E') {
+		j := i+1
+		if q[j]=='+' || q[j]=='-' { j++ }
+		if j<len(q) && isDigit(q[j]) {
+			for i = j; i<len(q) && isDigit(q[i]); i++ {}
+		}
+	}
+	return i
+}
+
+/*
+Normalizes a query, in order to use it as key for the statement cache.
+
+Comments are removed, whitespace is collapsed and literals are replaced by '?'.
+The second result is the query with the literals replaced by the bind variables
+:v1, :v2, ... and the third result holds the source text of the literals.
+
+Queries, that can't be normalized reliably (bind variables, charset introducers,
+executable comments) are rejected (ok=false).
+*/
+func normalize(q string) (key string,param string,lits []string,ok bool) {
+	var kb,pb bytes.Buffer
+	space := false
+	write := func(s string) {
+		if space && kb.Len()>0 {
+			kb.WriteByte(' ')
+			pb.WriteByte(' ')
+		}
+		space = false
+		kb.WriteString(s)
+		pb.WriteString(s)
+	}
+	i := 0
+	for i<len(q) {
+		ch := q[i]
+		switch {
+		case isSpace(ch):
+			space = true
+			i++
+		case ch=='#' || (ch=='-' && i+2<len(q) && q[i+1]=='-' && isSpace(q[i+2])):
+			for ; i<len(q) && q[i]!='\n'; i++ {}
+			space = true
+		case ch=='/' && i+1<len(q) && q[i+1]=='*':
+			if i+2<len(q) && q[i+2]=='!' { return }
+			j := bytes.Index([]byte(q[i+2:]),[]byte("*/"))
+			if j<0 { return }
+			i += j+4
+			space = true
+		case ch==':' || ch=='?':
+			return
+		case ch=='\'' || ch=='"':
+			if pb.Len()>0 && !space && isIdent(pb.Bytes()[pb.Len()-1]) { return } /* _utf8'...', x'...' */
+			j := scanQuoted(q,i)
+			if j<0 { return }
+			lits = append(lits,q[i:j])
+			if space && kb.Len()>0 {
+				kb.WriteByte(' ')
+				pb.WriteByte(' ')
+			}
+			space = false
+			kb.WriteByte('?')
+			fmt.Fprintf(&pb,":v%d",len(lits))
+			i = j
+		case ch=='`':
+			j := scanQuoted(q,i)
+			if j<0 { return }
+			write(q[i:j])
+			i = j
+		case isDigit(ch) || (ch=='.' && i+1<len(q) && isDigit(q[i+1]) && (pb.Len()==0 || !isIdent(pb.Bytes()[pb.Len()-1]))):
+			j := scanNumber(q,i)
+			if j<len(q) && isIdent(q[j]) {
+				/* An identifier, starting with a digit. */
+				for ; j<len(q) && isIdent(q[j]); j++ {}
+				write(q[i:j])
+				i = j
+				continue
+			}
+			lits = append(lits,q[i:j])
+			if space && kb.Len()>0 {
+				kb.WriteByte(' ')
+				pb.WriteByte(' ')
+			}
+			space = false
+			kb.WriteByte('?')
+			fmt.Fprintf(&pb,":v%d",len(lits))
+			i = j
+		case isIdent(ch):
+			j := i
+			for ; j<len(q) && isIdent(q[j]); j++ {}
+			write(q[i:j])
+			i = j
+		default:
+			write(q[i:i+1])
+			i++
+		}
+	}
+	return kb.String(),pb.String(),lits,true
+}
+
+/*
+Converts the source text of a literal (as returned by normalize) into a *sqlparser.SQLVal.
+*/
+func literal(src string) *sqlparser.SQLVal {
+	typ,val := sqlparser.NewStringTokenizer(src).Scan()
+	switch typ {
+	case sqlparser.STRING: return sqlparser.NewStrVal(val)
+	case sqlparser.INTEGRAL: return sqlparser.NewIntVal(val)
+	case sqlparser.FLOAT: return sqlparser.NewFloatVal(val)
+	case sqlparser.HEXNUM: return sqlparser.NewHexNum(val)
+	}
+	return nil
+}
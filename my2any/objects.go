@@ -0,0 +1,290 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "regexp"
+import "strings"
+import "time"
+import "fmt"
+
+/*
+CREATE/ALTER/DROP of views, triggers and events. The parser doesn't know the
+options (ALGORITHM, DEFINER, SQL SECURITY) and drops the body of views.
+*/
+var objectRx = regexp.MustCompile(`^(?is)\s*(create|alter|drop)\s+(or\s+replace\s+)?(?:algorithm\s*=\s*\w+\s+)?(?:definer\s*=\s*\S+\s+)?(?:sql\s+security\s+\w+\s+)?(view|trigger|event)\s+(.*)$`)
+var checkOptionRx = regexp.MustCompile(`(?is)\s+with\s+(?:(cascaded|local)\s+)?check\s+option\s*;?\s*$`)
+var beginEndRx = regexp.MustCompile(`(?is)^begin\s(.*)\send$`)
+
+/* The versioned comments (slash-star-bang NNNNN), that mysqldump writes around the DDL of views and triggers. */
+var versionedRx = regexp.MustCompile(`(?s)/\*!\d*(.*?)\*/`)
+
+/*
+Replaces the versioned comments by their contents, as MySQL executes them.
+*/
+func unwrapVersioned(query string) string {
+	if !strings.Contains(query,"/*!") { return query }
+	return versionedRx.ReplaceAllString(query," $1 ")
+}
+
+/*
+Optional interface for Syntaxers, that translate views and triggers.
+The statements are preprocessed, the names are qualified.
+*/
+type ObjectSyntaxer interface{
+	EncodeView(v *View) (string,error)
+	EncodeDropView(names []sqlparser.TableName, ifExists bool) (string,error)
+	EncodeTrigger(t *Trigger) (string,error)
+	EncodeDropTrigger(name sqlparser.TableName, ifExists bool) (string,error)
+}
+
+/*
+CREATE [OR REPLACE] VIEW, without the MySQL-only options. ALTER VIEW is
+translated into CREATE OR REPLACE VIEW.
+*/
+type View struct{
+	Replace bool
+	Name    sqlparser.TableName
+	Columns sqlparser.Columns
+	Select  sqlparser.SelectStatement
+
+	/* "", "cascaded" or "local". */
+	CheckOption string
+}
+
+/*
+CREATE TRIGGER name {BEFORE|AFTER} {INSERT|UPDATE|DELETE} ON table FOR EACH ROW body.
+
+The body is a sequence of SET NEW.col = expr and INSERT, UPDATE or DELETE
+statements. The qualifiers NEW and OLD are lower-cased.
+*/
+type Trigger struct{
+	Name   sqlparser.TableName
+	Timing string
+	Event  string
+	Table  sqlparser.TableName
+	Body   []TriggerStmt
+}
+
+/*
+A statement of a trigger body: either Set or Stmt is set.
+*/
+type TriggerStmt struct{
+	Set  sqlparser.UpdateExprs
+	Stmt sqlparser.Statement
+}
+
+func notSupported(what string) error {
+	return mysql.NewSQLError(ERNotSupportedYet,"42000","This version of MySQL doesn't yet support '%s'",what)
+}
+
+func (p *loadParser) tableName(schema string) (tn sqlparser.TableName,err error) {
+	var name string
+	if name,err = p.ident(); err!=nil { return }
+	tn.Name = sqlparser.NewTableIdent(name)
+	if p.typ=='.' {
+		p.next()
+		tn.Qualifier = tn.Name
+		if name,err = p.ident(); err!=nil { return }
+		tn.Name = sqlparser.NewTableIdent(name)
+	}
+	if tn.Qualifier.IsEmpty() && schema!="" { tn.Qualifier = sqlparser.NewTableIdent(schema) }
+	return
+}
+func (p *loadParser) end() error {
+	if p.typ==';' { p.next() }
+	if p.typ!=0 { return fmt.Errorf("%s: unsupported clause near '%s'",p.stmt,p.val) }
+	return nil
+}
+
+/*
+Parses [(col, ...)] AS select [WITH [CASCADED | LOCAL] CHECK OPTION].
+*/
+func (g *Gateway) parseView(p *loadParser,text,schema string,v *View) (err error) {
+	if p.typ=='(' {
+		p.next()
+		for p.typ!=')' {
+			var col string
+			if col,err = p.ident(); err!=nil { return }
+			v.Columns = append(v.Columns,sqlparser.NewColIdent(col))
+			if p.typ==',' { p.next() }
+		}
+		p.next()
+	}
+	if err = p.expect("as"); err!=nil { return }
+	text = text[p.pos:]
+	if m := checkOptionRx.FindStringSubmatchIndex(text); m!=nil {
+		v.CheckOption = "cascaded"
+		if m[2]>=0 { v.CheckOption = strings.ToLower(text[m[2]:m[3]]) }
+		text = text[:m[0]]
+	}
+	st,err := decodeSql(text)
+	if err!=nil { return }
+	sel,ok := st.(sqlparser.SelectStatement)
+	if !ok { return fmt.Errorf("CREATE VIEW: a SELECT statement is expected") }
+	g.Syn.Preprocess(sel,schema)
+	v.Select = sel
+	return
+}
+
+func triggerRefs(node sqlparser.SQLNode) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if cn,ok := node.(*sqlparser.ColName); ok && cn.Qualifier.Qualifier.IsEmpty() {
+			switch q := strings.ToLower(cn.Qualifier.Name.String()); q {
+			case "new","old": cn.Qualifier.Name = sqlparser.NewTableIdent(q)
+			}
+		}
+		return true,nil
+	},node)
+}
+
+/*
+Splits the body of a trigger into statements and parses them.
+*/
+func (g *Gateway) parseTriggerBody(body,schema string) (stmts []TriggerStmt,err error) {
+	body = strings.TrimSpace(body)
+	body = strings.TrimSpace(strings.TrimSuffix(body,";"))
+	if m := beginEndRx.FindStringSubmatch(body); m!=nil { body = m[1] }
+
+	pieces,err := splitTokens(body,';',"CREATE TRIGGER")
+	if err!=nil { return nil,err }
+
+	for _,piece := range pieces {
+		piece = strings.TrimSpace(piece)
+		if piece=="" { continue }
+		word := strings.ToLower(strings.Fields(piece)[0])
+		switch word {
+		case "set":
+			/* SET NEW.a = x, NEW.b = y is parsed as UPDATE t SET NEW.a = x, NEW.b = y. */
+			st,err := decodeSql("update t "+piece)
+			if err!=nil { return nil,err }
+			set := st.(*sqlparser.Update).Exprs
+			for _,ue := range set {
+				if !strings.EqualFold(ue.Name.Qualifier.Name.String(),"new") {
+					return nil,notSupported("SET of variables in triggers")
+				}
+				g.Syn.Preprocess(&sqlparser.Select{SelectExprs:sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr:ue.Expr}}},schema)
+			}
+			triggerRefs(set)
+			stmts = append(stmts,TriggerStmt{Set:set})
+		case "insert","update","delete":
+			st,err := decodeSql(piece)
+			if err!=nil { return nil,err }
+			g.Syn.Preprocess(st,schema)
+			if err = g.check(st); err!=nil { return nil,err }
+			triggerRefs(st)
+			stmts = append(stmts,TriggerStmt{Stmt:st})
+		default:
+			return nil,notSupported(strings.ToUpper(word)+" in triggers")
+		}
+	}
+	return
+}
+
+/*
+Implements CREATE/ALTER/DROP VIEW and CREATE/DROP TRIGGER, using the
+ObjectSyntaxer. Events are not supported. The versioned comments must be
+unwrapped already.
+*/
+func (g *Gateway) objectDDL(c *mysql.Conn,query string,callback func(*sqltypes.Result) error) error {
+	m := objectRx.FindStringSubmatch(query)
+	verb,kind,text := strings.ToLower(m[1]),strings.ToLower(m[3]),m[4]
+	if kind=="event" {
+		return notSupported(strings.ToUpper(verb)+" EVENT (use a scheduler of the backend, like pg_cron)")
+	}
+	osyn,ok := g.Syn.(ObjectSyntaxer)
+	if !ok { return notSupported(strings.ToUpper(verb+" "+kind)) }
+	schema := c.SchemaName
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(text),stmt:strings.ToUpper(verb+" "+kind)}
+	p.next()
+
+	var names []sqlparser.TableName
+	var nq string
+	var err error
+	switch {
+	case verb=="drop":
+		ifExists := false
+		if p.accept("if") {
+			if err = p.expect("exists"); err!=nil { return err }
+			ifExists = true
+		}
+		for {
+			tn,err := p.tableName(schema)
+			if err!=nil { return err }
+			names = append(names,tn)
+			if kind=="trigger" || p.typ!=',' { break }
+			p.next()
+		}
+		p.accept("restrict","cascade")
+		if err = p.end(); err!=nil { return err }
+		if kind=="view" {
+			nq,err = osyn.EncodeDropView(names,ifExists)
+		} else {
+			nq,err = osyn.EncodeDropTrigger(names[0],ifExists)
+		}
+	case kind=="view":
+		v := &View{Replace:verb=="alter" || m[2]!=""}
+		if v.Name,err = p.tableName(schema); err!=nil { return err }
+		if err = g.parseView(p,text,schema,v); err!=nil { return err }
+		names = append(names,v.Name)
+		nq,err = osyn.EncodeView(v)
+	case verb=="create":
+		t := new(Trigger)
+		if p.accept("if") {
+			return notSupported("CREATE TRIGGER IF NOT EXISTS")
+		}
+		if t.Name,err = p.tableName(schema); err!=nil { return err }
+		t.Timing = p.word()
+		if err = p.expect("before","after"); err!=nil { return err }
+		t.Event = p.word()
+		if err = p.expect("insert","update","delete"); err!=nil { return err }
+		if err = p.expect("on"); err!=nil { return err }
+		if t.Table,err = p.tableName(schema); err!=nil { return err }
+		if err = p.expect("for"); err!=nil { return err }
+		if err = p.expect("each"); err!=nil { return err }
+		if err = p.expect("row"); err!=nil { return err }
+		if p.word()=="follows" || p.word()=="precedes" {
+			return notSupported("FOLLOWS/PRECEDES in triggers")
+		}
+		if t.Body,err = g.parseTriggerBody(text[p.pos:],schema); err!=nil { return err }
+		names = append(names,t.Name,t.Table)
+		nq,err = osyn.EncodeTrigger(t)
+	default:
+		return notSupported("ALTER TRIGGER")
+	}
+	if err!=nil { return err }
+
+	ddl := &sqlparser.DDL{Action:sqlparser.CreateStr,Table:names[0]}
+	if verb=="drop" { ddl.Action = sqlparser.DropStr }
+	if g.Firewall!=nil {
+		for _,tn := range names {
+			ddl.Table = tn
+			if _,err = g.Firewall.Check(c.User,c.SchemaName,ddl); err!=nil { return err }
+		}
+	}
+
+	c.ClientData.(*ClientData).LastWrite = time.Now()
+	err = g.executeScript(c,nq,callback)
+	for _,tn := range names {
+		ddl.Table = tn
+		g.invalidate(ddl)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "fmt"
+import "reflect"
+import "testing"
+
+func TestParseTriggerBody(t *testing.T) {
+	cases := []struct{
+		body string
+		want []string
+	}{
+		{"SET NEW.a = 1",[]string{"set new.a = 1"}},
+		{"BEGIN SET NEW.a = NEW.b + 1, New.c = 'x'; INSERT INTO log VALUES (OLD.a); END;",[]string{"set new.a = new.b + 1, new.c = 'x'","insert into log values (old.a)"}},
+		{"begin delete from log where id = old.id; update cnt set n = n - 1; end",[]string{"delete from log where id = old.id","update cnt set n = n - 1"}},
+		{"SET @x = 1",nil},
+		{"BEGIN CALL p(); END",nil},
+		{"IF NEW.a > 1 THEN SET NEW.a = 1; END IF",nil},
+	}
+	g := &Gateway{Syn:DefaultSyntaxer}
+	for _,c := range cases {
+		stmts,err := g.parseTriggerBody(c.body,"s")
+		if c.want==nil {
+			if err==nil { t.Errorf("parseTriggerBody(%q): no error",c.body) }
+			continue
+		}
+		if err!=nil {
+			t.Errorf("parseTriggerBody(%q): %v",c.body,err)
+			continue
+		}
+		var got []string
+		for _,s := range stmts {
+			if s.Stmt!=nil {
+				got = append(got,sqlparser.String(s.Stmt))
+			} else {
+				got = append(got,"set "+sqlparser.String(s.Set))
+			}
+		}
+		if !reflect.DeepEqual(got,c.want) {
+			t.Errorf("parseTriggerBody(%q) = %q, want %q",c.body,got,c.want)
+		}
+	}
+}
+
+/* An ObjectSyntaxer, that describes the parsed statements. */
+type objSyntaxer struct{ DefaultSyntaxerClass }
+func (objSyntaxer) EncodeView(v *View) (string,error) {
+	return fmt.Sprintf("view %s%s replace %v check %q: %s",sqlparser.String(v.Name),sqlparser.String(v.Columns),v.Replace,v.CheckOption,sqlparser.String(v.Select)),nil
+}
+func (objSyntaxer) EncodeDropView(names []sqlparser.TableName, ifExists bool) (string,error) {
+	return fmt.Sprintf("drop view %s if exists %v",sqlparser.String(sqlparser.TableNames(names)),ifExists),nil
+}
+func (objSyntaxer) EncodeTrigger(t *Trigger) (string,error) {
+	return fmt.Sprintf("trigger %s %s %s on %s: %d statements",sqlparser.String(t.Name),t.Timing,t.Event,sqlparser.String(t.Table),len(t.Body)),nil
+}
+func (objSyntaxer) EncodeDropTrigger(name sqlparser.TableName, ifExists bool) (string,error) {
+	return fmt.Sprintf("drop trigger %s if exists %v",sqlparser.String(name),ifExists),nil
+}
+
+func TestObjectDDL(t *testing.T) {
+	cases := []struct{
+		query, want string
+	}{
+		{"CREATE OR REPLACE VIEW v (x) AS SELECT 1 WITH CHECK OPTION",`view s.v(x) replace true check "cascaded": select 1 from dual`},
+		{"alter algorithm=merge definer=`a`@`%` sql security invoker view o.v as select a from t with local check option;",`view o.v replace true check "local": select a from t`},
+		{"DROP VIEW IF EXISTS v, o.w",`drop view s.v, o.w if exists true`},
+		{"CREATE TRIGGER tr AFTER DELETE ON t FOR EACH ROW BEGIN DELETE FROM log WHERE id = OLD.id; END",`trigger s.tr after delete on s.t: 1 statements`},
+		{"DROP TRIGGER o.tr",`drop trigger o.tr if exists false`},
+		/* As written by mysqldump: */
+		{"/*!50001 CREATE ALGORITHM=UNDEFINED */\n/*!50013 DEFINER=`root`@`localhost` SQL SECURITY DEFINER */\n/*!50001 VIEW `v` AS select `t`.`a` AS `a` from `t` */",
+			`view s.v replace false check "": select t.a as a from t`},
+		{"/*!50001 DROP VIEW IF EXISTS `v`*/",`drop view s.v if exists true`},
+		{"/*!50003 CREATE*/ /*!50017 DEFINER=`root`@`localhost`*/ /*!50003 TRIGGER `tr` BEFORE INSERT ON `t` FOR EACH ROW SET NEW.a = 1 */",
+			`trigger s.tr before insert on s.t: 1 statements`},
+	}
+	db,err := sql.Open("my2any-log","")
+	if err!=nil { t.Fatal(err) }
+	defer db.Close()
+	g := &Gateway{DB:db,Syn:objSyntaxer{},SF:DefaultSpecialFeatures}
+	c := &mysql.Conn{SchemaName:"s",ClientData:new(ClientData)}
+	for _,cs := range cases {
+		testDriver.log = nil
+		if err = g.ComQuery(c,cs.query,func(*sqltypes.Result) error { return nil }); err!=nil {
+			t.Errorf("%q: %v",cs.query,err)
+			continue
+		}
+		if !reflect.DeepEqual(testDriver.log,[]string{cs.want}) {
+			t.Errorf("%q executed %q, want %q",cs.query,testDriver.log,cs.want)
+		}
+	}
+	for _,q := range []string{"CREATE EVENT e ON SCHEDULE EVERY 1 DAY DO DELETE FROM t","ALTER TRIGGER tr","CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW FOLLOWS x SET NEW.a = 1"} {
+		if err = g.ComQuery(c,q,func(*sqltypes.Result) error { return nil }); err==nil {
+			t.Errorf("%q: no error",q)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "bufio"
+import "os"
+import "path/filepath"
+import "regexp"
+import "fmt"
+
+var outfileRx = regexp.MustCompile(`(?i)\sinto\s+(?:outfile|dumpfile)\s`)
+
+const (
+	ERFileExists = 1086
+	ERTooManyRows = 1172
+	EROptionPreventsStatement = 1290
+)
+
+/*
+Removes the INTO OUTFILE/DUMPFILE clause from a SELECT statement. Returns the
+statement without the clause and the options of the clause (nil, if there is
+no such clause).
+*/
+func parseOutfile(query string) (string,*loadData,bool,error) {
+	p := &loadParser{tkn:sqlparser.NewStringTokenizer(query),stmt:"INTO OUTFILE"}
+	for p.next(); p.typ!=0; p.next() {
+		if p.word()!="into" { continue }
+		start := p.pos
+		p.next()
+		dump := p.word()=="dumpfile"
+		if !p.accept("outfile","dumpfile") { continue }
+		ld := &loadData{fieldsTerm:"\t",escaped:"\\",linesTerm:"\n"}
+		var err error
+		if ld.file,err = p.str(); err!=nil { return "",nil,false,err }
+		if !dump {
+			if err = p.format(ld); err!=nil { return "",nil,false,err }
+		}
+		if len(ld.enclosed)>1 || len(ld.escaped)>1 {
+			return "",nil,false,fmt.Errorf("INTO OUTFILE: ENCLOSED BY and ESCAPED BY must be a single character")
+		}
+		return query[:start]+query[p.pos:],ld,dump,nil
+	}
+	return query,nil,false,nil
+}
+
+/*
+Resolves the file name in the export directory. Like secure_file_priv, only files
+directly in the export directory can be written.
+*/
+func (g *Gateway) exportPath(name string) (string,error) {
+	return dirPath(g.ExportDir,"export",name)
+}
+
+/*
+Resolves the file name in the import directory, for LOAD DATA INFILE.
+*/
+func (g *Gateway) importPath(name string) (string,error) {
+	return dirPath(g.ImportDir,"import",name)
+}
+
+func dirPath(dir,kind,name string) (string,error) {
+	if dir=="" {
+		return "",mysql.NewSQLError(EROptionPreventsStatement,"HY000","The gateway has no %s directory configured so it cannot execute this statement",kind)
+	}
+	dir,err := filepath.Abs(dir)
+	if err!=nil { return "",err }
+	path := name
+	if !filepath.IsAbs(path) { path = filepath.Join(dir,path) }
+	path = filepath.Clean(path)
+	if filepath.Dir(path)!=dir {
+		return "",mysql.NewSQLError(EROptionPreventsStatement,"HY000","The gateway can only access files in its %s directory, not '%s'",kind,name)
+	}
+	return path,nil
+}
+
+/*
+Writes a field value the way SELECT ... INTO OUTFILE does: The escape character,
+the enclosure, and the first characters of the terminators are escaped, NULL is
+written as \N (or NULL, if there is no escape character).
+*/
+func (ld *loadData) writeField(w *bufio.Writer, v sqltypes.Value) {
+	if v.IsNull() {
+		if ld.escaped=="" {
+			w.WriteString("NULL")
+		} else {
+			w.WriteString(ld.escaped+"N")
+		}
+		return
+	}
+	enclose := ld.enclosed!="" && (!ld.optionally || v.IsQuoted())
+	if enclose { w.WriteString(ld.enclosed) }
+	for _,ch := range v.Raw() {
+		if ld.escaped!="" {
+			esc := ch==ld.escaped[0] || ch==0 ||
+				(ld.enclosed!="" && ch==ld.enclosed[0]) ||
+				(!enclose && ld.fieldsTerm!="" && ch==ld.fieldsTerm[0]) ||
+				(!enclose && ld.linesTerm!="" && ch==ld.linesTerm[0])
+			if esc {
+				w.WriteString(ld.escaped)
+				if ch==0 { ch = '0' }
+			}
+		}
+		w.WriteByte(ch)
+	}
+	if enclose { w.WriteString(ld.enclosed) }
+}
+
+/*
+Implements SELECT ... INTO OUTFILE/DUMPFILE: The SELECT is executed and the rows
+are written to a file in the export directory (Gateway.ExportDir) of the gateway host.
+*/
+func (g *Gateway) exportSelect(c *mysql.Conn,sel string,ld *loadData,dump bool,callback func(*sqltypes.Result) error) error {
+	path,err := g.exportPath(ld.file)
+	if err!=nil { return err }
+	
+	pv := sqlparser.Preview(sel)
+	_,nq,err := g.translate(c,sel,&pv)
+	if err!=nil { return err }
+	if pv!=sqlparser.StmtSelect { return fmt.Errorf("INTO OUTFILE requires a SELECT statement") }
+	
+	f,err := os.OpenFile(path,os.O_WRONLY|os.O_CREATE|os.O_EXCL,0640)
+	if os.IsExist(err) {
+		return mysql.NewSQLError(ERFileExists,"HY000","File '%s' already exists",ld.file)
+	}
+	if err!=nil { return err }
+	w := bufio.NewWriter(f)
+	
+	var n uint64
+	rs,err := g.readDB(c,sel).Query(nq)
+	if err==nil {
+		err = g.streamRows(c,rs,func(r *sqltypes.Result) error {
+			for _,row := range r.Rows {
+				n++
+				if dump {
+					if n>1 { return mysql.NewSQLError(ERTooManyRows,"42000","Result consisted of more than one row") }
+					for _,v := range row { w.Write(v.Raw()) }
+					continue
+				}
+				w.WriteString(ld.linesStart)
+				for i,v := range row {
+					if i>0 { w.WriteString(ld.fieldsTerm) }
+					ld.writeField(w,v)
+				}
+				w.WriteString(ld.linesTerm)
+			}
+			return nil
+		})
+		if err==nil { err = w.Flush() }
+	}
+	if cerr := f.Close(); err==nil { err = cerr }
+	if err!=nil {
+		os.Remove(path)
+		return err
+	}
+	return callback(&sqltypes.Result{RowsAffected:n})
+}
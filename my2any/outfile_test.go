@@ -0,0 +1,80 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "bufio"
+import "bytes"
+import "testing"
+
+func TestParseOutfile(t *testing.T) {
+	cases := []struct{
+		query, sel, file, fieldsTerm string
+		dump, found bool
+	}{
+		{"select a from t into outfile 'x.txt' fields terminated by ','","select a from t","x.txt",",",false,true},
+		{"select a into outfile 'x.txt' from t where b = 1","select a from t where b = 1","x.txt","\t",false,true},
+		{"SELECT a FROM t INTO DUMPFILE 'x.bin'","SELECT a FROM t","x.bin","\t",true,true},
+		{"select 'into outfile' from t","select 'into outfile' from t","","",false,false},
+	}
+	for _,c := range cases {
+		sel,ld,dump,err := parseOutfile(c.query)
+		if err!=nil {
+			t.Errorf("parseOutfile(%q): %v",c.query,err)
+			continue
+		}
+		if sel!=c.sel || (ld!=nil)!=c.found || dump!=c.dump {
+			t.Errorf("parseOutfile(%q) = %q, %v, %v; want %q, %v, %v",c.query,sel,ld!=nil,dump,c.sel,c.found,c.dump)
+			continue
+		}
+		if ld!=nil && (ld.file!=c.file || ld.fieldsTerm!=c.fieldsTerm) {
+			t.Errorf("parseOutfile(%q): file %q, fields terminated by %q; want %q, %q",c.query,ld.file,ld.fieldsTerm,c.file,c.fieldsTerm)
+		}
+	}
+	if _,_,_,err := parseOutfile("select a from t into outfile x"); err==nil {
+		t.Errorf("parseOutfile without a file name: no error")
+	}
+}
+
+func TestWriteField(t *testing.T) {
+	tab := &loadData{fieldsTerm:"\t",escaped:"\\",linesTerm:"\n"}
+	csv := &loadData{fieldsTerm:",",enclosed:"\"",optionally:true,escaped:"\\",linesTerm:"\n"}
+	raw := &loadData{fieldsTerm:",",linesTerm:"\n"}
+	cases := []struct{
+		ld   *loadData
+		v    sqltypes.Value
+		want string
+	}{
+		{tab,sqltypes.NULL,"\\N"},
+		{tab,sqltypes.NewVarChar("a\tb\nc\\d\x00"),"a\\\tb\\\nc\\\\d\\0"},
+		{tab,sqltypes.NewInt64(-5),"-5"},
+		{csv,sqltypes.NewVarChar("say \"hi\", ok"),"\"say \\\"hi\\\", ok\""},
+		{csv,sqltypes.NewInt64(5),"5"},
+		{raw,sqltypes.NULL,"NULL"},
+		{raw,sqltypes.NewVarChar("a,b"),"a,b"},
+	}
+	for _,c := range cases {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		c.ld.writeField(w,c.v)
+		w.Flush()
+		if buf.String()!=c.want {
+			t.Errorf("writeField(%v) = %q, want %q",c.v,buf.String(),c.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "sort"
+import "sync"
+import "fmt"
+
+/*
+A Dialect, as registered by a dialect package.
+*/
+type Dialect struct{
+	/* The name of the database/sql driver. */
+	Driver string
+	
+	/*
+	Sets up the plugins of a new Gateway, opened with dsn. CC, Syn and SF
+	are preset to DefaultConverter, DefaultSyntaxer and DefaultSpecialFeatures.
+	*/
+	Setup func(g *Gateway, dsn string)
+}
+
+var dialectsLock sync.RWMutex
+var dialects = make(map[string]Dialect)
+
+/*
+Makes a dialect available by the provided name. Like sql.Register, it is meant
+to be called from the init function of a dialect package, and it panics, if
+Register is called twice with the same name or if Setup is nil.
+*/
+func Register(name string, d Dialect) {
+	dialectsLock.Lock()
+	defer dialectsLock.Unlock()
+	if d.Setup==nil { panic("my2any: Register dialect "+name+" without Setup") }
+	if _,dup := dialects[name]; dup { panic("my2any: Register called twice for dialect "+name) }
+	dialects[name] = d
+}
+
+/*
+Returns a sorted list of the names of the registered dialects.
+*/
+func Dialects() []string {
+	dialectsLock.RLock()
+	defer dialectsLock.RUnlock()
+	list := make([]string,0,len(dialects))
+	for name := range dialects { list = append(list,name) }
+	sort.Strings(list)
+	return list
+}
+
+/*
+Opens the database with the driver of the dialect and returns a Gateway, that is
+configured for the dialect. The Gateway has a statement cache of the default size.
+
+The dialect package (and the database/sql driver, if the dialect package doesn't
+import it itself) must be imported, like:
+
+	import _ "github.com/a-mail-group/yoursql/my2any/my2pg"
+*/
+func Open(dialect, dsn string) (*Gateway,error) {
+	dialectsLock.RLock()
+	d,ok := dialects[dialect]
+	dialectsLock.RUnlock()
+	if !ok { return nil,fmt.Errorf("my2any: unknown dialect %q (forgotten import?)",dialect) }
+	
+	db,err := sql.Open(d.Driver,dsn)
+	if err!=nil { return nil,err }
+	
+	g := &Gateway{
+		DB:    db,
+		CC:    DefaultConverter,
+		Syn:   DefaultSyntaxer,
+		SF:    DefaultSpecialFeatures,
+		Cache: NewStmtCache(0),
+	}
+	d.Setup(g,dsn)
+	return g,nil
+}
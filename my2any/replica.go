@@ -0,0 +1,101 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "database/sql"
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "regexp"
+import "strconv"
+import "sync/atomic"
+import "time"
+
+var primaryRx = regexp.MustCompile(`(?i)/\*\s*primary\s*\*/`)
+
+type Balance int
+const (
+	/* Picks the replicas one after another. */
+	RoundRobin Balance = iota
+	
+	/* Picks the replica with the fewest connections in use. */
+	LeastConn
+)
+
+/*
+A set of read replicas of Gateway.DB (the primary).
+
+Autocommit SELECT statements are sent to a replica, unless:
+	- the client is in a transaction (it is pinned to the primary),
+	- the client has written to the primary within the last ReadYourWrites,
+	- the statement contains a comment, that consists of the word "primary",
+	- the statement is a locking read (FOR UPDATE, LOCK IN SHARE MODE).
+*/
+type ReplicaSet struct{
+	DBs     []*sql.DB
+	Balance Balance
+	
+	/* The time, reads of a client go to the primary after it has written. */
+	ReadYourWrites time.Duration
+	
+	next uint32
+}
+func NewReplicaSet(dbs ...*sql.DB) *ReplicaSet {
+	return &ReplicaSet{DBs:dbs,Balance:RoundRobin,ReadYourWrites:time.Second}
+}
+
+func (r *ReplicaSet) pick() *sql.DB {
+	switch len(r.DBs) {
+	case 0: return nil
+	case 1: return r.DBs[0]
+	}
+	if r.Balance==LeastConn {
+		best,inUse := r.DBs[0],r.DBs[0].Stats().InUse
+		for _,db := range r.DBs[1:] {
+			if n := db.Stats().InUse; n<inUse { best,inUse = db,n }
+		}
+		return best
+	}
+	n := atomic.AddUint32(&r.next,1)
+	return r.DBs[int(n%uint32(len(r.DBs)))]
+}
+
+/*
+Returns the database for a SELECT statement.
+*/
+func (g *Gateway) readDB(c *mysql.Conn,query string) GenericDB {
+	cd := c.ClientData.(*ClientData)
+	r := g.Replicas
+	switch {
+	case r==nil,cd.Tx!=nil,cd.Conn!=nil: return g.getDB(c)
+	case time.Since(cd.LastWrite)<r.ReadYourWrites: return g.DB
+	case primaryRx.MatchString(query),lockClauseRx.MatchString(query): return g.DB
+	}
+	if db := r.pick(); db!=nil { return db }
+	return g.DB
+}
+
+/*
+Returns the connection pools of the Gateway ("primary", "replica0", ...),
+suitable for metrics.Metrics.Pools.
+*/
+func (g *Gateway) Pools() map[string]*sql.DB {
+	pools := map[string]*sql.DB{"primary":g.DB}
+	if g.Replicas!=nil {
+		for i,db := range g.Replicas.DBs { pools["replica"+strconv.Itoa(i)] = db }
+	}
+	return pools
+}
@@ -0,0 +1,263 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "encoding/json"
+import "os"
+import "reflect"
+import "strconv"
+import "strings"
+import "fmt"
+
+/*
+A rule, that replaces matching statements.
+
+Fingerprint is an example query. It matches all queries, that differ from it
+only in literals, whitespace and comments. The Replacement may refer to the
+literals of the query as :v1, :v2, ...
+
+Pattern is a statement with wildcards (:name). It is compared with the parsed
+statement node by node (so keyword case, whitespace and comments don't matter).
+A wildcard matches a single expression; the Replacement may refer to it as :name.
+Each wildcard may appear only once in the pattern. Patterns can't contain
+literals: the statement cache matches them against statements, whose literals
+are replaced by :v1, :v2, ..., so they are rejected by Compile. Use a wildcard
+or a Fingerprint instead.
+
+The Replacement is a MySQL statement, which is preprocessed and translated
+instead of the original statement.
+*/
+type RewriteRule struct{
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	
+	fp  string
+	pat sqlparser.Statement
+}
+
+type RewriteRules struct{
+	Rules []*RewriteRule `json:"rules"`
+}
+
+/*
+Loads rewrite rules from a JSON file, like:
+
+	{"rules": [
+		{"name": "no-force-index",
+		 "pattern": "select * from orders force index (created) where customer = :c",
+		 "replacement": "select * from orders where customer = :c"}
+	]}
+*/
+func LoadRewriteRules(path string) (*RewriteRules,error) {
+	f,err := os.Open(path)
+	if err!=nil { return nil,err }
+	defer f.Close()
+	rr := new(RewriteRules)
+	if err = json.NewDecoder(f).Decode(rr); err!=nil { return nil,err }
+	if err = rr.Compile(); err!=nil { return nil,err }
+	return rr,nil
+}
+
+/*
+Prepares the rules for matching. Must be called, if the rules are not loaded
+with LoadRewriteRules.
+*/
+func (rr *RewriteRules) Compile() error {
+	for _,r := range rr.Rules {
+		if _,err := decodeSql(r.Replacement); err!=nil {
+			return fmt.Errorf("rewrite rule %q: replacement: %v",r.Name,err)
+		}
+		switch {
+		case r.Fingerprint!="":
+			key,_,_,ok := normalize(r.Fingerprint)
+			if !ok { return fmt.Errorf("rewrite rule %q: can't fingerprint %q",r.Name,r.Fingerprint) }
+			r.fp = key
+		case r.Pattern!="":
+			st,err := decodeSql(r.Pattern)
+			if err!=nil { return fmt.Errorf("rewrite rule %q: pattern: %v",r.Name,err) }
+			if hasLiterals(st) { return fmt.Errorf("rewrite rule %q: pattern contains literals, use wildcards",r.Name) }
+			if w := repeatedWildcard(st); w!="" { return fmt.Errorf("rewrite rule %q: wildcard %s appears more than once",r.Name,w) }
+			r.pat = st
+		default:
+			return fmt.Errorf("rewrite rule %q: neither fingerprint nor pattern",r.Name)
+		}
+	}
+	return nil
+}
+
+func hasLiterals(st sqlparser.Statement) (found bool) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if v,ok := node.(*sqlparser.SQLVal); ok && v.Type!=sqlparser.ValArg { found = true }
+		return !found,nil
+	},st)
+	return
+}
+
+func repeatedWildcard(st sqlparser.Statement) (found string) {
+	seen := make(map[string]bool)
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if v,ok := node.(*sqlparser.SQLVal); ok && v.Type==sqlparser.ValArg {
+			if seen[string(v.Val)] { found = string(v.Val) }
+			seen[string(v.Val)] = true
+		}
+		return found=="",nil
+	},st)
+	return
+}
+
+var colIdentType = reflect.TypeOf(sqlparser.ColIdent{})
+var tableIdentType = reflect.TypeOf(sqlparser.TableIdent{})
+var commentsType = reflect.TypeOf(sqlparser.Comments{})
+
+/*
+Compares a pattern with a statement node by node. A wildcard of the pattern
+matches any single expression, which is stored in caps.
+*/
+func matchNode(p,s reflect.Value,caps map[string]sqlparser.Expr) bool {
+	if p.Kind()==reflect.Interface && !p.IsNil() && p.CanInterface() {
+		if w,ok := p.Interface().(*sqlparser.SQLVal); ok && w.Type==sqlparser.ValArg {
+			if s.IsNil() || !s.CanInterface() { return false }
+			e,ok := s.Interface().(sqlparser.Expr)
+			if ok { caps[string(w.Val)] = e }
+			return ok
+		}
+	}
+	if p.Type()!=s.Type() { return false }
+	switch {
+	case p.Type()==commentsType:
+		return true
+	case p.Type()==colIdentType && p.CanInterface():
+		return p.Interface().(sqlparser.ColIdent).Equal(s.Interface().(sqlparser.ColIdent))
+	case p.Type()==tableIdentType && p.CanInterface():
+		return p.Interface().(sqlparser.TableIdent).String()==s.Interface().(sqlparser.TableIdent).String()
+	}
+	switch p.Kind() {
+	case reflect.Interface,reflect.Ptr:
+		if p.IsNil() || s.IsNil() { return p.IsNil()==s.IsNil() }
+		if p.Elem().Type()!=s.Elem().Type() { return false }
+		return matchNode(p.Elem(),s.Elem(),caps)
+	case reflect.Struct:
+		for i := 0; i<p.NumField(); i++ {
+			if !matchNode(p.Field(i),s.Field(i),caps) { return false }
+		}
+		return true
+	case reflect.Slice,reflect.Array:
+		if p.Len()!=s.Len() { return false }
+		for i := 0; i<p.Len(); i++ {
+			if !matchNode(p.Index(i),s.Index(i),caps) { return false }
+		}
+		return true
+	case reflect.String:
+		return p.String()==s.String()
+	case reflect.Bool:
+		return p.Bool()==s.Bool()
+	case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64:
+		return p.Int()==s.Int()
+	case reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64:
+		return p.Uint()==s.Uint()
+	}
+	return false
+}
+
+/*
+A copy of the captured expression, in parentheses, unless it is a single term,
+so the precedence of the replacement's operators can't change it.
+*/
+func captured(e sqlparser.Expr) sqlparser.Expr {
+	if st,err := sqlparser.Parse("select "+sqlparser.String(e)); err==nil {
+		e = st.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr
+	}
+	switch e.(type) {
+	case *sqlparser.ColName,*sqlparser.SQLVal,*sqlparser.NullVal,sqlparser.BoolVal,*sqlparser.FuncExpr,
+		*sqlparser.ParenExpr,sqlparser.ValTuple,*sqlparser.Subquery:
+		return e
+	}
+	return &sqlparser.ParenExpr{Expr:e}
+}
+
+/*
+Replaces the wildcards of the replacement with the captured expressions.
+*/
+func substitute(v reflect.Value,caps map[string]sqlparser.Expr) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() || !v.CanInterface() { return }
+		if w,ok := v.Interface().(*sqlparser.SQLVal); ok && w.Type==sqlparser.ValArg {
+			if e,ok := caps[string(w.Val)]; ok && v.CanSet() {
+				if ne := reflect.ValueOf(captured(e)); ne.Type().AssignableTo(v.Type()) { v.Set(ne) }
+			}
+			return
+		}
+		substitute(v.Elem(),caps)
+	case reflect.Ptr:
+		if !v.IsNil() { substitute(v.Elem(),caps) }
+	case reflect.Struct:
+		for i := 0; i<v.NumField(); i++ { substitute(v.Field(i),caps) }
+	case reflect.Slice:
+		for i := 0; i<v.Len(); i++ { substitute(v.Index(i),caps) }
+	}
+}
+
+/*
+Returns the replacement for the statement (fp is its fingerprint), or nil.
+The statement must not be preprocessed yet.
+*/
+func (rr *RewriteRules) match(fp string,st sqlparser.Statement) sqlparser.Statement {
+	for _,r := range rr.Rules {
+		var caps map[string]sqlparser.Expr
+		if r.pat==nil {
+			if r.fp=="" || r.fp!=fp { continue }
+		} else {
+			caps = make(map[string]sqlparser.Expr)
+			if !matchNode(reflect.ValueOf(&r.pat).Elem(),reflect.ValueOf(&st).Elem(),caps) { continue }
+		}
+		nst,err := decodeSql(r.Replacement)
+		if err!=nil { return nil }
+		if len(caps)>0 { substitute(reflect.ValueOf(&nst).Elem(),caps) }
+		return nst
+	}
+	return nil
+}
+
+/*
+Applies the rewrite rules to a statement and preprocesses the result. fp must
+be taken before the statement is preprocessed. If lits is not nil, the bind
+variables :v1, :v2, ... of the replacement are substituted with the literals.
+*/
+func (g *Gateway) rewrite(st sqlparser.Statement,fp,schema string,lits []*sqlparser.SQLVal) sqlparser.Statement {
+	nst := g.Rules.match(fp,st)
+	if nst==nil {
+		g.Syn.Preprocess(st,schema)
+		return st
+	}
+	if lits!=nil {
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			v,ok := node.(*sqlparser.SQLVal)
+			if !ok || v.Type!=sqlparser.ValArg || !strings.HasPrefix(string(v.Val),":v") { return true,nil }
+			if n,err := strconv.Atoi(string(v.Val[2:])); err==nil && n>=1 && n<=len(lits) && lits[n-1]!=nil {
+				*v = *lits[n-1]
+			}
+			return true,nil
+		},nst)
+	}
+	g.Syn.Preprocess(nst,schema)
+	return nst
+}
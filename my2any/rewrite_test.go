@@ -0,0 +1,73 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "testing"
+
+func TestRewriteCompile(t *testing.T) {
+	cases := []struct{
+		rule RewriteRule
+		ok bool
+	}{
+		{RewriteRule{Name:"wildcard",Pattern:"select * from t where a = :x",Replacement:"select * from u where a = :x"},true},
+		{RewriteRule{Name:"fingerprint",Fingerprint:"select * from t where a = 1",Replacement:"select * from u where a = :v1"},true},
+		{RewriteRule{Name:"literal",Pattern:"select * from t where a = 1",Replacement:"select * from u"},false},
+		{RewriteRule{Name:"string",Pattern:"select * from t where a = 'x'",Replacement:"select * from u"},false},
+		{RewriteRule{Name:"repeated",Pattern:"select * from t where a = :x and b = :x",Replacement:"select * from t where a = :x"},false},
+		{RewriteRule{Name:"empty",Replacement:"select 1"},false},
+		{RewriteRule{Name:"bad replacement",Pattern:"select * from t",Replacement:"selec"},false},
+	}
+	for _,c := range cases {
+		rule := c.rule
+		err := (&RewriteRules{Rules:[]*RewriteRule{&rule}}).Compile()
+		if (err==nil)!=c.ok { t.Errorf("Compile(%s) = %v, want ok %v",c.rule.Name,err,c.ok) }
+	}
+}
+
+func TestRewriteMatch(t *testing.T) {
+	rr := &RewriteRules{Rules:[]*RewriteRule{
+		{Name:"wildcard",Pattern:"select * from t where a = :x and b = :y",Replacement:"select * from u where b = :y and a = :x"},
+		{Name:"precedence",Pattern:"select :x from s",Replacement:"select 2 * :x from s"},
+		{Name:"fingerprint",Fingerprint:"select name from cust where id = 1",Replacement:"select name from customers where id = :v1"},
+	}}
+	if err := rr.Compile(); err!=nil { t.Fatal(err) }
+
+	/* The uncached path sees the literals, the cached path the bind variables. */
+	cases := []struct{ query, want string }{
+		{"select * from t where a = 1 and b = 2","select * from u where b = 2 and a = 1"},
+		{"SELECT * FROM t WHERE A = 1 AND b = 'x'","select * from u where b = 'x' and a = 1"},
+		{"select * from t where a = :v1 and b = :v2","select * from u where b = :v2 and a = :v1"},
+		{"select * from t where a = 1 or c = 2 and b = 3",""},
+		{"select * from t where a = 1 + 2 and b = f(3)","select * from u where b = f(3) and a = (1 + 2)"},
+		{"select a + 1 from s","select 2 * (a + 1) from s"},
+		{"select name from cust where id = 42","select name from customers where id = :v1"},
+		{"select * from t where a = 1",""},
+		{"select * from t where b = 1 and a = 2",""},
+	}
+	for _,c := range cases {
+		st,err := sqlparser.Parse(c.query)
+		if err!=nil { t.Fatalf("%s: %v",c.query,err) }
+		fp,_,_,_ := normalize(c.query)
+		got := ""
+		if nst := rr.match(fp,st); nst!=nil { got = sqlparser.String(nst) }
+		if got!=c.want {
+			t.Errorf("match(%q) = %q, want %q",c.query,got,c.want)
+		}
+	}
+}
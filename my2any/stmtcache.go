@@ -0,0 +1,216 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "container/list"
+import "strings"
+import "sync"
+
+type stmtEntry struct{
+	key    string
+	text   string /* The translated statement, with placeholders for the literals. */
+	pv     int
+	tables []string
+	nocache bool  /* The statement can't be parameterized. */
+}
+
+type CacheStats struct{
+	Size, Capacity int
+	Hits, Misses, Invalidations uint64
+}
+
+/*
+LRU cache mapping normalized MySQL statements (literals replaced by placeholders)
+to their translation and statement kind.
+
+The translation may include catalog-derived information (like the RETURNING
+clause of INSERT statements), so entries are indexed by the tables they refer
+to and are invalidated, when the gateway executes DDL on one of these tables.
+
+The cache is only used, if the Syntaxer implements the Binder interface.
+*/
+type StmtCache struct{
+	lock   sync.Mutex
+	size   int
+	lru    *list.List
+	items  map[string]*list.Element
+	tables map[string]map[*list.Element]bool
+	stats  CacheStats
+}
+func NewStmtCache(size int) *StmtCache {
+	if size<1 { size = 1024 }
+	return &StmtCache{
+		size: size,
+		lru: list.New(),
+		items: make(map[string]*list.Element),
+		tables: make(map[string]map[*list.Element]bool),
+	}
+}
+
+func tableKey(tn sqlparser.TableName) string {
+	return tn.Qualifier.String()+"."+tn.Name.String()
+}
+func referencedTables(st sqlparser.Statement) (tabs []string) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if tn,ok := node.(sqlparser.TableName); ok && !tn.Name.IsEmpty() {
+			tabs = append(tabs,tableKey(tn))
+		}
+		return true,nil
+	},st)
+	return
+}
+func countArgs(st sqlparser.Statement) (n int) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if v,ok := node.(*sqlparser.SQLVal); ok && v.Type==sqlparser.ValArg { n++ }
+		return true,nil
+	},st)
+	return
+}
+
+func (sc *StmtCache) get(key string) *stmtEntry {
+	sc.lock.Lock(); defer sc.lock.Unlock()
+	elem,ok := sc.items[key]
+	if !ok {
+		sc.stats.Misses++
+		return nil
+	}
+	sc.stats.Hits++
+	sc.lru.MoveToFront(elem)
+	return elem.Value.(*stmtEntry)
+}
+func (sc *StmtCache) remove(elem *list.Element) {
+	e := elem.Value.(*stmtEntry)
+	for _,t := range e.tables {
+		delete(sc.tables[t],elem)
+		if len(sc.tables[t])==0 { delete(sc.tables,t) }
+	}
+	delete(sc.items,e.key)
+	sc.lru.Remove(elem)
+}
+func (sc *StmtCache) put(e *stmtEntry) {
+	sc.lock.Lock(); defer sc.lock.Unlock()
+	if old,ok := sc.items[e.key]; ok { sc.remove(old) }
+	elem := sc.lru.PushFront(e)
+	sc.items[e.key] = elem
+	for _,t := range e.tables {
+		m := sc.tables[t]
+		if m==nil {
+			m = make(map[*list.Element]bool)
+			sc.tables[t] = m
+		}
+		m[elem] = true
+	}
+	for sc.lru.Len()>sc.size {
+		sc.remove(sc.lru.Back())
+	}
+}
+
+/*
+Removes all entries referring to the tables, the (DDL) statement refers to.
+*/
+func (sc *StmtCache) Invalidate(st sqlparser.Statement) {
+	for _,t := range referencedTables(st) {
+		sc.invalidate(t)
+	}
+}
+
+/* Removes all entries referring to the table. */
+func (sc *StmtCache) InvalidateTable(schema,name string) {
+	sc.invalidate(schema+"."+name)
+}
+func (sc *StmtCache) invalidate(t string) {
+	sc.lock.Lock(); defer sc.lock.Unlock()
+	for elem := range sc.tables[t] {
+		sc.remove(elem)
+		sc.stats.Invalidations++
+	}
+}
+
+/* Removes all entries. */
+func (sc *StmtCache) Flush() {
+	sc.lock.Lock(); defer sc.lock.Unlock()
+	sc.stats.Invalidations += uint64(sc.lru.Len())
+	sc.lru.Init()
+	sc.items = make(map[string]*list.Element)
+	sc.tables = make(map[string]map[*list.Element]bool)
+}
+
+func (sc *StmtCache) Stats() CacheStats {
+	sc.lock.Lock(); defer sc.lock.Unlock()
+	s := sc.stats
+	s.Size = sc.lru.Len()
+	s.Capacity = sc.size
+	return s
+}
+
+func (sc *StmtCache) build(g *Gateway,db GenericDB,key,param,schema string,pv,nlits int) *stmtEntry {
+	e := &stmtEntry{key:key,nocache:true}
+	st,err := decodeSql(param)
+	if err!=nil { return e }
+	if countArgs(st)!=nlits { return e }
+	if g.Rules==nil {
+		g.Syn.Preprocess(st,schema)
+	} else {
+		/* The bind variables of the replacement are substituted by Bind. */
+		st = g.rewrite(st,key[strings.IndexByte(key,0)+1:],schema,nil)
+	}
+	/* Translated without the cache, which reports the error. */
+	if g.check(st)!=nil { return e }
+	if lr,ok := g.SF.(LiteralRewriter); ok && lr.DependsOnLiterals(db,st) { return e }
+	e.tables = referencedTables(st)
+	if nnq,ok := g.SF.Rewrite(db,st,&pv) ; ok {
+		e.text = nnq
+	} else {
+		e.text = g.Syn.EncodeAny(st)
+	}
+	e.pv = pv
+	e.nocache = false
+	return e
+}
+
+/*
+Translates the query using the cache. Returns false, if the query has to be translated
+without the cache.
+*/
+func (sc *StmtCache) translate(g *Gateway,db GenericDB,schema,query string,pvp *int) (string,bool) {
+	b,ok := g.Syn.(Binder)
+	if !ok { return "",false }
+	switch *pvp {
+	case sqlparser.StmtSelect,sqlparser.StmtInsert,sqlparser.StmtUpdate,sqlparser.StmtDelete:
+	default: return "",false
+	}
+	key,param,lits,ok := normalize(query)
+	if !ok { return "",false }
+	key = schema+"\x00"+key
+
+	vals := make([]*sqlparser.SQLVal,len(lits))
+	for i,l := range lits {
+		vals[i] = literal(l)
+		if vals[i]==nil { return "",false }
+	}
+
+	e := sc.get(key)
+	if e==nil {
+		e = sc.build(g,db,key,param,schema,*pvp,len(lits))
+		sc.put(e)
+	}
+	if e.nocache { return "",false }
+	*pvp = e.pv
+	return b.Bind(e.text,vals),true
+}
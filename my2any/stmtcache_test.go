@@ -0,0 +1,172 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/sqltypes"
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "reflect"
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := []struct{
+		query, key, param string
+		lits []string
+		ok bool
+	}{
+		{"SELECT * FROM t WHERE id = 42","SELECT * FROM t WHERE id = ?","SELECT * FROM t WHERE id = :v1",[]string{"42"},true},
+		{"select  name\n from t where a='x' and b = 1.5e3 -- c\n","select name from t where a=? and b = ?","select name from t where a=:v1 and b = :v2",[]string{"'x'","1.5e3"},true},
+		{"/* c */ select 1","select ?","select :v1",[]string{"1"},true},
+		{"select 'it''s', \"a\\\"b\"","select ?, ?","select :v1, :v2",[]string{"'it''s'","\"a\\\"b\""},true},
+		{"select `a b`, 1abc from t","select `a b`, 1abc from t","select `a b`, 1abc from t",nil,true},
+		{"select 0x1F","select ?","select :v1",[]string{"0x1F"},true},
+		{"select _utf8'x'","","",nil,false},
+		{"select ?","","",nil,false},
+		{"select :name","","",nil,false},
+		{"/*!40101 set names utf8 */","","",nil,false},
+		{"select 'unterminated","","",nil,false},
+	}
+	for _,c := range cases {
+		key,param,lits,ok := normalize(c.query)
+		if ok!=c.ok {
+			t.Errorf("normalize(%q): ok = %v, want %v",c.query,ok,c.ok)
+			continue
+		}
+		if !ok { continue }
+		if key!=c.key || param!=c.param || !reflect.DeepEqual(lits,c.lits) {
+			t.Errorf("normalize(%q) = %q, %q, %q; want %q, %q, %q",c.query,key,param,lits,c.key,c.param,c.lits)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	cases := []struct{
+		src string
+		typ sqlparser.ValType
+		val string
+	}{
+		{"'it''s'",sqlparser.StrVal,"it's"},
+		{"\"a\\nb\"",sqlparser.StrVal,"a\nb"},
+		{"42",sqlparser.IntVal,"42"},
+		{"1.5e3",sqlparser.FloatVal,"1.5e3"},
+	}
+	for _,c := range cases {
+		v := literal(c.src)
+		if v==nil || v.Type!=c.typ || string(v.Val)!=c.val {
+			t.Errorf("literal(%q) = %#v, want type %v value %q",c.src,v,c.typ,c.val)
+		}
+	}
+}
+
+func TestBindVars(t *testing.T) {
+	lits := []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("x")),sqlparser.NewIntVal([]byte("42"))}
+	cases := []struct{ tmpl, want string }{
+		{"select * from t where a = :v1 and b = :v2","select * from t where a = 'x' and b = 42"},
+		{"select ':v1', \":v1\", `:v1`, [:v1] from t","select ':v1', \":v1\", `:v1`, [:v1] from t"},
+		{"select :v3, :v0, :x","select :v3, :v0, :x"},
+		{"select :v2+:v2","select 42+42"},
+		{"select 'unterminated :v1","select 'unterminated :v1"},
+	}
+	for _,c := range cases {
+		if got := BindVars(c.tmpl,lits,nil); got!=c.want {
+			t.Errorf("BindVars(%q) = %q, want %q",c.tmpl,got,c.want)
+		}
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	sc := NewStmtCache(2)
+	sc.put(&stmtEntry{key:"a",text:"A",tables:[]string{"s.t"}})
+	sc.put(&stmtEntry{key:"b",text:"B",tables:[]string{"s.u"}})
+	if e := sc.get("a"); e==nil || e.text!="A" { t.Fatalf("get(a) = %v",e) }
+
+	/* b is the least recently used entry. */
+	sc.put(&stmtEntry{key:"c",text:"C",tables:[]string{"s.t","s.u"}})
+	if sc.get("b")!=nil { t.Errorf("b was not evicted") }
+
+	sc.InvalidateTable("s","t")
+	for _,k := range []string{"a","c"} {
+		if sc.get(k)!=nil { t.Errorf("%s was not invalidated",k) }
+	}
+	if len(sc.tables)!=0 { t.Errorf("table index not empty: %v",sc.tables) }
+
+	s := sc.Stats()
+	want := CacheStats{Size:0,Capacity:2,Hits:1,Misses:3,Invalidations:2}
+	if s!=want { t.Errorf("Stats() = %+v, want %+v",s,want) }
+}
+
+type bindSyntaxer struct{ DefaultSyntaxerClass }
+func (bindSyntaxer) Bind(tmpl string, lits []*sqlparser.SQLVal) string { return BindVars(tmpl,lits,nil) }
+
+/* Like my2mssql: the first column is left out, if it is 0. */
+type zeroIdFeatures struct{ DefaultSpecialFeaturesClass }
+func (zeroIdFeatures) Rewrite(db GenericDB,ast sqlparser.Statement,pvp *int) (string,bool) {
+	i,ok := ast.(*sqlparser.Insert)
+	if !ok { return "",false }
+	row := i.Rows.(sqlparser.Values)[0]
+	if v,ok := row[0].(*sqlparser.SQLVal); !ok || v.Type!=sqlparser.IntVal || string(v.Val)!="0" { return "",false }
+	return sqlparser.String(&sqlparser.Insert{Action:i.Action,Table:i.Table,Columns:i.Columns[1:],Rows:sqlparser.Values{row[1:]}}),true
+}
+func (zeroIdFeatures) DependsOnLiterals(db GenericDB,ast sqlparser.Statement) bool {
+	i,ok := ast.(*sqlparser.Insert)
+	if !ok { return false }
+	v,ok := i.Rows.(sqlparser.Values)[0][0].(*sqlparser.SQLVal)
+	return ok && v.Type==sqlparser.ValArg
+}
+
+func TestStmtCacheLiteralRewriter(t *testing.T) {
+	g := &Gateway{Syn:bindSyntaxer{},SF:zeroIdFeatures{},Cache:NewStmtCache(10)}
+	c := &mysql.Conn{ClientData:new(ClientData)}
+	cases := []struct{
+		query  string
+		cached bool
+	}{
+		{"insert into t (id, x) values (0, 'a')",false},
+		{"insert into t (id, x) values (7, 'a')",false},
+		{"select * from t where id = 0",true},
+	}
+	for _,cs := range cases {
+		st,err := decodeSql(cs.query)
+		if err!=nil { t.Fatal(err) }
+		pv := sqlparser.Preview(cs.query)
+		_,want,err := g.encode(c,cs.query,st,&pv)
+		if err!=nil { t.Fatal(err) }
+		
+		/* Twice, the second time from the cache. */
+		for i := 0; i<2; i++ {
+			pv = sqlparser.Preview(cs.query)
+			got,ok := g.Cache.translate(g,nil,"",cs.query,&pv)
+			if ok!=cs.cached || (ok && got!=want) {
+				t.Errorf("cached translation of %q = %q, %v; uncached %q, want cached %v",cs.query,got,ok,want,cs.cached)
+			}
+		}
+	}
+}
+
+func TestExplainBypassesCache(t *testing.T) {
+	g := &Gateway{Syn:bindSyntaxer{},SF:DefaultSpecialFeatures,Cache:NewStmtCache(10),Admins:[]string{"admin"}}
+	c := &mysql.Conn{User:"admin",ClientData:new(ClientData)}
+	var nq string
+	err := g.ComQuery(c,"GATEWAY EXPLAIN TRANSLATION select * from t where id = 1",func(sr *sqltypes.Result) error {
+		nq = sr.Rows[0][1].ToString()
+		return nil
+	})
+	if err!=nil { t.Fatal(err) }
+	if nq!="select * from t where id = 1" { t.Errorf("translation %q",nq) }
+	if s := g.Cache.Stats(); s!=(CacheStats{Capacity:10}) { t.Errorf("Stats() = %+v after EXPLAIN TRANSLATION",s) }
+}
@@ -18,9 +18,33 @@
 package my2any
 
 import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "strings"
+import "regexp"
 
+/*
+The locking clause of a SELECT, including MySQL 8's FOR SHARE, NOWAIT and SKIP LOCKED.
+*/
+var lockClauseRx = regexp.MustCompile(`(?i)\s(for\s+update|for\s+share|lock\s+in\s+share\s+mode)(\s+nowait|\s+skip\s+locked)?\s*;?\s*$`)
+
+/*
+Parses the statement. The parser doesn't know FOR SHARE, NOWAIT and SKIP LOCKED,
+so the locking clause is cut off and stored in Select.Lock (or Union.Lock) as
+" for update", " for share" or " lock in share mode", followed by " nowait" or
+" skip locked".
+*/
 func decodeSql(s string) (sqlparser.Statement, error) {
-	return sqlparser.Parse(s)
+	m := lockClauseRx.FindStringSubmatchIndex(s)
+	if m==nil { return sqlparser.Parse(s) }
+	lock := " "+strings.ToLower(strings.Join(strings.Fields(s[m[2]:m[3]])," "))
+	if m[4]>=0 { lock += " "+strings.ToLower(strings.Join(strings.Fields(s[m[4]:m[5]])," ")) }
+	st,err := sqlparser.Parse(s[:m[0]])
+	if err!=nil { return sqlparser.Parse(s) }
+	switch v := st.(type) {
+	case *sqlparser.Select: v.Lock = lock
+	case *sqlparser.Union: v.Lock = lock
+	default: return sqlparser.Parse(s)
+	}
+	return st,nil
 }
 func encodeSql(stmt sqlparser.Statement) string {
 	return sqlparser.String(stmt)
@@ -39,6 +63,145 @@ func (DefaultSyntaxerClass) EncodeAny(ast sqlparser.Statement) string { return s
 func (DefaultSyntaxerClass) EncodeInsert(ast sqlparser.Statement) string { return sqlparser.String(ast) }
 var DefaultSyntaxer Syntaxer = DefaultSyntaxerClass{}
 
+/*
+Optional interface for Syntaxers, required by the StmtCache.
+
+Cached statements are translated with their literals replaced by the bind
+variables :v1, :v2, ... . Bind() substitutes the literals into such a
+translated statement.
+*/
+type Binder interface{
+	Bind(tmpl string, lits []*sqlparser.SQLVal) string
+}
+
+/*
+Optional interface for Syntaxers, that can't translate every statement. Check
+is called after Preprocess and fails, if the statement can't be translated.
+*/
+type Checker interface{
+	Check(ast sqlparser.Statement) error
+}
+
+/*
+Helper for Binder implementations of dialects, which emit the bind variables
+as they are (:v1, :v2, ...). Substitutes the literals, formatted with f, for
+the bind variables outside of quoted strings and identifiers.
+*/
+func BindVars(tmpl string, lits []*sqlparser.SQLVal, f sqlparser.NodeFormatter) string {
+	buf := sqlparser.NewTrackedBuffer(f)
+	for i := 0; i<len(tmpl); i++ {
+		ch := tmpl[i]
+		switch {
+		case ch=='\'' || ch=='"' || ch=='`' || ch=='[':
+			end := ch
+			if ch=='[' { end = ']' }
+			j := strings.IndexByte(tmpl[i+1:],end)
+			if j<0 {
+				buf.WriteString(tmpl[i:])
+				i = len(tmpl)
+				continue
+			}
+			buf.WriteString(tmpl[i:i+j+2])
+			i += j+1
+		case ch==':' && i+2<len(tmpl) && tmpl[i+1]=='v' && '0'<=tmpl[i+2] && tmpl[i+2]<='9':
+			n := 0
+			j := i+2
+			for ; j<len(tmpl) && '0'<=tmpl[j] && tmpl[j]<='9'; j++ {
+				n = n*10 + int(tmpl[j]-'0')
+			}
+			if n<1 || n>len(lits) {
+				buf.WriteString(tmpl[i:j])
+			} else {
+				buf.Myprintf("%v",lits[n-1])
+			}
+			i = j-1
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+/*
+A NodeFormatter of a dialect, which emits the bind variables as they are
+(:v1, :v2, ...). Embedded into a Syntaxer, it implements Binder.
+*/
+type Formatter sqlparser.NodeFormatter
+
+func (f Formatter) Encode(node sqlparser.SQLNode) string {
+	buf := sqlparser.NewTrackedBuffer(sqlparser.NodeFormatter(f))
+	buf.Myprintf("%v",node)
+	return buf.String()
+}
+
+/*
+Substitutes the literals into a statement translated with the bind variables :v1, :v2, ...
+*/
+func (f Formatter) Bind(tmpl string, lits []*sqlparser.SQLVal) string {
+	return BindVars(tmpl,lits,sqlparser.NodeFormatter(f))
+}
+
+var integerRx = regexp.MustCompile(`^(tiny|small|medium|big)?int(eger)?$`)
+
+/*
+Reports, whether t (in lower case) is one of MySQL's integer types.
+*/
+func IsInteger(t string) bool {
+	return integerRx.MatchString(t)
+}
+
+/*
+Returns the i-th argument of a function call, or NULL if it is missing.
+*/
+func Arg(exprs sqlparser.SelectExprs,i int) sqlparser.SQLNode {
+	if i<len(exprs) { return exprs[i] }
+	return &sqlparser.NullVal{}
+}
+
+/*
+Reports, whether the FROM clause is MySQL's dummy table DUAL.
+*/
+func IsDual(from sqlparser.TableExprs) bool {
+	if len(from)!=1 { return false }
+	ate,ok := from[0].(*sqlparser.AliasedTableExpr)
+	if !ok { return false }
+	tn,ok := ate.Expr.(sqlparser.TableName)
+	return ok && tn.Qualifier.IsEmpty() && strings.ToLower(tn.Name.String())=="dual"
+}
+
+/*
+Helper for dialects, which don't support (non-unique) KEY or INDEX clauses in
+CREATE TABLE. Removes them from ts and returns them, so they can be emitted as
+separate CREATE INDEX statements (see FormatIndex). SPATIAL and FULLTEXT
+indexes are dropped.
+*/
+func SplitIndexes(ts *sqlparser.TableSpec) (extra []*sqlparser.IndexDefinition) {
+	var keep []*sqlparser.IndexDefinition
+	for _,idx := range ts.Indexes {
+		if idx.Info.Primary || idx.Info.Unique {
+			keep = append(keep,idx)
+		} else if !idx.Info.Spatial && !strings.Contains(strings.ToLower(idx.Info.Type),"fulltext") {
+			extra = append(extra,idx)
+		}
+	}
+	ts.Indexes = keep
+	return
+}
+
+/*
+Formats "<table>_<index> on <table> (<columns>)" for a CREATE INDEX statement.
+Index names are unique per schema, so they are prefixed with the table name.
+*/
+func FormatIndex(buf *sqlparser.TrackedBuffer, table sqlparser.TableName, idx *sqlparser.IndexDefinition) {
+	name := sqlparser.NewColIdent(table.Name.String()+"_"+idx.Info.Name.String())
+	buf.Myprintf("%v on %v (",name,table)
+	for i,c := range idx.Columns {
+		if i>0 { buf.WriteString(", ") }
+		buf.Myprintf("%v",c.Column)
+	}
+	buf.WriteString(")")
+}
+
 func Qualify(stmt sqlparser.Statement, schema string) error {
 	g := func(tn *sqlparser.TableName) {
 		if tn.Name.IsEmpty() { return }
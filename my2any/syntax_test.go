@@ -0,0 +1,52 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package my2any
+
+import "gopkg.in/src-d/go-vitess.v0/vt/sqlparser"
+import "testing"
+
+func TestDecodeSql(t *testing.T) {
+	cases := []struct{
+		query, lock, want string
+	}{
+		{"select * from t for update"," for update","select * from t for update"},
+		{"SELECT * FROM t LOCK IN SHARE MODE"," lock in share mode","select * from t lock in share mode"},
+		{"select * from t for share nowait;"," for share nowait","select * from t for share nowait"},
+		{"select a from t union select a from u FOR  UPDATE\tSKIP LOCKED"," for update skip locked","select a from t union select a from u for update skip locked"},
+		{"select 'for update' from t","","select 'for update' from t"},
+		{"update t set a = 1","","update t set a = 1"},
+	}
+	for _,c := range cases {
+		st,err := decodeSql(c.query)
+		if err!=nil {
+			t.Errorf("decodeSql(%q): %v",c.query,err)
+			continue
+		}
+		lock := ""
+		switch v := st.(type) {
+		case *sqlparser.Select: lock = v.Lock
+		case *sqlparser.Union: lock = v.Lock
+		}
+		if got := sqlparser.String(st); lock!=c.lock || got!=c.want {
+			t.Errorf("decodeSql(%q) = %q, lock %q; want %q, lock %q",c.query,got,lock,c.want,c.lock)
+		}
+	}
+	if _,err := decodeSql("select * from t for nothing"); err==nil {
+		t.Errorf("decodeSql: no error for an unknown locking clause")
+	}
+}
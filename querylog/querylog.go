@@ -0,0 +1,141 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Query logging for the gateways (my2any and generaldb).
+
+The gateways pass an Entry for every query to their Logger (if any). Loggers
+can be stacked, like:
+
+	gw.Log = &querylog.Slow{Threshold: time.Second, Logger: sink}
+*/
+package querylog
+
+import "encoding/json"
+import "io"
+import "os"
+import "sync"
+import "time"
+
+type Entry struct{
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Schema string    `json:"schema"`
+	
+	/* The query, as sent by the client. */
+	Query  string    `json:"query"`
+	
+	/* The query, as sent to the backend. Empty, if not translated. */
+	Translated string `json:"translated,omitempty"`
+	
+	Duration time.Duration `json:"-"`
+	Rows     uint64        `json:"rows"`
+	Error    string        `json:"error,omitempty"`
+	
+	/* Records with too few or too many fields (LOAD DATA). */
+	Warnings uint64 `json:"warnings,omitempty"`
+	
+	/* Set by Slow. */
+	Slow bool `json:"slow,omitempty"`
+}
+
+type Logger interface{
+	Log(e *Entry)
+}
+
+/*
+Passes entries, that took Threshold or longer, to Logger, and marks them as slow.
+Failed queries are passed as well, if Errors is set.
+*/
+type Slow struct{
+	Threshold time.Duration
+	Errors    bool
+	Logger    Logger
+}
+func (s *Slow) Log(e *Entry) {
+	if e.Duration>=s.Threshold {
+		e.Slow = true
+	} else if !(s.Errors && e.Error!="") {
+		return
+	}
+	s.Logger.Log(e)
+}
+
+/*
+Passes every entry to all Loggers.
+*/
+type Multi []Logger
+func (m Multi) Log(e *Entry) {
+	for _,l := range m { l.Log(e) }
+}
+
+type jsonEntry struct{
+	*Entry
+	Seconds float64 `json:"duration"`
+}
+
+/*
+Writes the entries as JSON lines (one object per line). The duration is written
+in seconds.
+*/
+type JSONLines struct{
+	lock sync.Mutex
+	w    io.Writer
+	path string
+}
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w:w}
+}
+
+/*
+Opens (or creates) a JSON lines file for appending.
+*/
+func OpenFile(path string) (*JSONLines,error) {
+	f,err := os.OpenFile(path,os.O_WRONLY|os.O_APPEND|os.O_CREATE,0640)
+	if err!=nil { return nil,err }
+	return &JSONLines{w:f,path:path},nil
+}
+
+func (j *JSONLines) Log(e *Entry) {
+	data,err := json.Marshal(jsonEntry{e,e.Duration.Seconds()})
+	if err!=nil { return }
+	data = append(data,'\n')
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.w.Write(data)
+}
+
+/*
+Reopens the file, after it has been moved away by a log rotation.
+*/
+func (j *JSONLines) Reopen() error {
+	if j.path=="" { return nil }
+	f,err := os.OpenFile(j.path,os.O_WRONLY|os.O_APPEND|os.O_CREATE,0640)
+	if err!=nil { return err }
+	j.lock.Lock()
+	old := j.w
+	j.w = f
+	j.lock.Unlock()
+	if c,ok := old.(io.Closer); ok { c.Close() }
+	return nil
+}
+
+func (j *JSONLines) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if c,ok := j.w.(io.Closer); ok && j.path!="" { return c.Close() }
+	return nil
+}
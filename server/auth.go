@@ -0,0 +1,133 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package server
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "gopkg.in/src-d/go-vitess.v0/vt/proto/query"
+import "crypto/subtle"
+import "net"
+import "sync"
+
+const CachingSha2Password = "caching_sha2_password"
+
+const (
+	ERSecureTransportRequired = 3159
+	SSSecureTransportRequired = "HY000"
+)
+
+type AuthEntry struct{
+	Password string
+
+	/*
+	mysql.MysqlNativePassword (default), mysql.MysqlClearPassword or CachingSha2Password.
+
+	CachingSha2Password always performs the full authentication, so it is only
+	available over TLS or Unix domain sockets.
+	*/
+	Method string
+
+	/* Like REQUIRE SSL: Reject this user on unencrypted TCP connections. */
+	RequireSecureTransport bool
+}
+
+type userData string
+func (u userData) Get() *query.VTGateCallerID {
+	return &query.VTGateCallerID{Username: string(u)}
+}
+
+/*
+A mysql.AuthServer with per-user authentication methods and transport requirements.
+*/
+type AuthServer struct{
+	Lock    sync.RWMutex
+	Entries map[string]*AuthEntry
+}
+func NewAuthServer() *AuthServer {
+	return &AuthServer{Entries: make(map[string]*AuthEntry)}
+}
+func (a *AuthServer) lookup(user string) *AuthEntry {
+	a.Lock.RLock(); defer a.Lock.RUnlock()
+	return a.Entries[user]
+}
+func (a *AuthServer) AuthMethod(user string) (string, error) {
+	e := a.lookup(user)
+	if e==nil { return mysql.MysqlNativePassword,nil }
+	switch e.Method {
+	case "",mysql.MysqlNativePassword:
+		/*
+		The native method is validated by ValidateHash(), which doesn't know the
+		connection. Use the full caching_sha2_password exchange, which ends up in
+		Negotiate(), where we can check the transport.
+		*/
+		if e.RequireSecureTransport { return CachingSha2Password,nil }
+		return mysql.MysqlNativePassword,nil
+	}
+	return e.Method,nil
+}
+func (a *AuthServer) Salt() ([]byte, error) {
+	return mysql.NewSalt()
+}
+func (a *AuthServer) ValidateHash(salt []byte, user string, authResponse []byte, remoteAddr net.Addr) (mysql.Getter, error) {
+	e := a.lookup(user)
+	if e==nil || e.RequireSecureTransport { return nil,accessDenied(user) }
+	hash := mysql.ScramblePassword(salt,[]byte(e.Password))
+	if subtle.ConstantTimeCompare(hash,authResponse)!=1 { return nil,accessDenied(user) }
+	return userData(user),nil
+}
+func (a *AuthServer) Negotiate(c *mysql.Conn, user string, remoteAddr net.Addr) (mysql.Getter, error) {
+	e := a.lookup(user)
+	if e==nil { return nil,accessDenied(user) }
+	secure := IsSecure(c,remoteAddr)
+	if e.RequireSecureTransport && !secure {
+		return nil,mysql.NewSQLError(ERSecureTransportRequired,SSSecureTransportRequired,"Connections using insecure transport are prohibited for user '%v'",user)
+	}
+	method,_ := a.AuthMethod(user)
+	var password string
+	var err error
+	switch method {
+	case CachingSha2Password:
+		if !secure {
+			return nil,mysql.NewSQLError(mysql.ERAccessDeniedError,mysql.SSAccessDeniedError,"%v requires a secure connection",CachingSha2Password)
+		}
+		/* Discard the scramble, we keep no cache, and request the full authentication. */
+		if _,err = c.ReadPacket(); err!=nil { return nil,err }
+		if err = c.WritePacket([]byte{0x01,0x04}); err!=nil { return nil,err }
+		password,err = mysql.AuthServerReadPacketString(c)
+	case mysql.MysqlClearPassword:
+		password,err = mysql.AuthServerReadPacketString(c)
+	default:
+		return nil,accessDenied(user)
+	}
+	if err!=nil { return nil,err }
+	if subtle.ConstantTimeCompare([]byte(password),[]byte(e.Password))!=1 { return nil,accessDenied(user) }
+	return userData(user),nil
+}
+
+/*
+Reports, whether the connection is encrypted or local (Unix domain socket).
+*/
+func IsSecure(c *mysql.Conn, remoteAddr net.Addr) bool {
+	if _,ok := remoteAddr.(*net.UnixAddr); ok { return true }
+	return c.Capabilities&mysql.CapabilityClientSSL!=0
+}
+
+func accessDenied(user string) error {
+	return mysql.NewSQLError(mysql.ERAccessDeniedError,mysql.SSAccessDeniedError,"Access denied for user '%v'",user)
+}
+
+var _ mysql.AuthServer = (*AuthServer)(nil)
@@ -0,0 +1,261 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package server
+
+import "github.com/klauspost/compress/zstd"
+import "bytes"
+import "compress/zlib"
+import "io"
+import "net"
+
+const (
+	CapabilityClientCompress = 1<<5
+	CapabilityClientZstd     = 1<<26
+	capabilityClientSSL      = 1<<11
+)
+
+/* Shorter payloads are sent uncompressed, like MySQL does. */
+const MinCompressLength = 50
+
+const maxCompressedPayload = 1<<24-1
+
+const (
+	compHandshake = iota /* The server greeting is not yet written. */
+	compResponse         /* Waiting for the client's handshake response. */
+	compAuth             /* Authenticating, until the server sends OK. */
+	compOn
+	compOff
+)
+
+/*
+The compressed protocol, implemented below vitess' mysql.Conn, which doesn't
+support it: The capabilities are added to the server greeting, the client's
+choice is taken from its handshake response, and after the OK packet, that
+ends the authentication, all packets are compressed.
+*/
+type compListener struct{
+	net.Listener
+	cfg *Config
+}
+func (l compListener) Accept() (net.Conn,error) {
+	c,err := l.Listener.Accept()
+	if err!=nil { return nil,err }
+	return &compConn{Conn:c,cfg:l.cfg},nil
+}
+
+type compConn struct{
+	net.Conn
+	cfg   *Config
+	state int
+	zstd  bool
+	level int
+
+	/* The sequence id of the next compressed packet. */
+	seq byte
+
+	/* Data, that is read but not yet returned. */
+	rbuf []byte
+
+	zw  *zlib.Writer
+	ze  *zstd.Encoder
+	zd  *zstd.Decoder
+	out bytes.Buffer
+}
+
+func (c *compConn) Close() error {
+	if c.ze!=nil { c.ze.Close() }
+	if c.zd!=nil { c.zd.Close() }
+	return c.Conn.Close()
+}
+
+/*
+Returns the offset of the lower capability flags in the server greeting
+(Protocol::HandshakeV10), or -1.
+*/
+func greetingCaps(b []byte) int {
+	/* header, protocol version, server version */
+	i := 5
+	for i<len(b) && b[i]!=0 { i++ }
+	/* NUL, connection id, auth-plugin-data-part-1, filler */
+	i += 1+4+8+1
+	if i+2>len(b) { return -1 }
+	return i
+}
+
+/*
+Adds the compression capabilities to the server greeting. vitess writes it
+with a single Write.
+*/
+func (c *compConn) greeting(b []byte) []byte {
+	i := greetingCaps(b)
+	if i<0 { return b }
+	nb := make([]byte,len(b))
+	copy(nb,b)
+	nb[i] |= CapabilityClientCompress
+	/* character set, status flags, upper capability flags */
+	if j := i+2+1+2; c.cfg.CompressZstd && j+2<=len(b) {
+		nb[j+1] |= CapabilityClientZstd>>24
+	}
+	return nb
+}
+
+/*
+Reads the handshake response (or SSL request) and takes the client's choice.
+*/
+func (c *compConn) response() error {
+	pkt,err := readPacket(c.Conn)
+	if err!=nil { return err }
+	n := len(pkt)-4
+	c.rbuf = pkt
+
+	c.state = compOff
+	if n<4 { return nil }
+	caps := uint32(pkt[4])|uint32(pkt[5])<<8|uint32(pkt[6])<<16|uint32(pkt[7])<<24
+	switch {
+	case caps&capabilityClientSSL!=0 && n==32:
+		/* SSL request: the rest is encrypted. */
+	case c.cfg.CompressZstd && caps&CapabilityClientZstd!=0:
+		/* The zstd_compression_level is the last field. */
+		c.state,c.zstd,c.level = compAuth,true,int(pkt[len(pkt)-1])
+	case caps&CapabilityClientCompress!=0:
+		c.state = compAuth
+	}
+	return nil
+}
+
+func (c *compConn) Read(b []byte) (int,error) {
+	if len(c.rbuf)==0 {
+		switch c.state {
+		case compResponse:
+			if err := c.response(); err!=nil { return 0,err }
+		case compOn:
+			for len(c.rbuf)==0 {
+				if err := c.readCompressed(); err!=nil { return 0,err }
+			}
+		default:
+			return c.Conn.Read(b)
+		}
+	}
+	n := copy(b,c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n,nil
+}
+
+func (c *compConn) readCompressed() error {
+	hdr := make([]byte,7)
+	if _,err := io.ReadFull(c.Conn,hdr); err!=nil { return err }
+	n := int(hdr[0])|int(hdr[1])<<8|int(hdr[2])<<16
+	ulen := int(hdr[4])|int(hdr[5])<<8|int(hdr[6])<<16
+	c.seq = hdr[3]+1
+	payload := make([]byte,n)
+	if _,err := io.ReadFull(c.Conn,payload); err!=nil { return err }
+	if ulen==0 {
+		c.rbuf = payload
+		return nil
+	}
+	var err error
+	if c.zstd {
+		if c.zd==nil {
+			if c.zd,err = zstd.NewReader(nil); err!=nil { return err }
+		}
+		c.rbuf,err = c.zd.DecodeAll(payload,make([]byte,0,ulen))
+		return err
+	}
+	zr,err := zlib.NewReader(bytes.NewReader(payload))
+	if err!=nil { return err }
+	c.rbuf = make([]byte,ulen)
+	_,err = io.ReadFull(zr,c.rbuf)
+	return err
+}
+
+func (c *compConn) Write(b []byte) (int,error) {
+	switch c.state {
+	case compHandshake:
+		c.state = compResponse
+		if _,err := c.Conn.Write(c.greeting(b)); err!=nil { return 0,err }
+		return len(b),nil
+	case compAuth:
+		/* Find the OK packet, compression starts right after it. */
+		for i := 0; i+4<len(b); {
+			end := i+4+(int(b[i])|int(b[i+1])<<8|int(b[i+2])<<16)
+			if end>len(b) { break }
+			if b[i+4]==0x00 {
+				if _,err := c.Conn.Write(b[:end]); err!=nil { return 0,err }
+				c.state,c.seq = compOn,0
+				if _,err := c.writeCompressed(b[end:]); err!=nil { return end,err }
+				return len(b),nil
+			}
+			i = end
+		}
+		return c.Conn.Write(b)
+	case compOn:
+		return c.writeCompressed(b)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *compConn) compress(b []byte) ([]byte,error) {
+	if c.zstd {
+		if c.ze==nil {
+			var err error
+			c.ze,err = zstd.NewWriter(nil,zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+			if err!=nil { return nil,err }
+		}
+		return c.ze.EncodeAll(b,nil),nil
+	}
+	c.out.Reset()
+	if c.zw==nil {
+		var err error
+		level := c.cfg.CompressLevel
+		if level==0 { level = zlib.DefaultCompression }
+		if c.zw,err = zlib.NewWriterLevel(&c.out,level); err!=nil { return nil,err }
+	} else {
+		c.zw.Reset(&c.out)
+	}
+	if _,err := c.zw.Write(b); err!=nil { return nil,err }
+	if err := c.zw.Close(); err!=nil { return nil,err }
+	return c.out.Bytes(),nil
+}
+
+/*
+Writes b as compressed packets. Their boundaries don't have to match the
+boundaries of the packets inside.
+*/
+func (c *compConn) writeCompressed(b []byte) (n int,err error) {
+	for len(b)>0 {
+		chunk := b
+		if len(chunk)>maxCompressedPayload { chunk = chunk[:maxCompressedPayload] }
+		payload,ulen := chunk,0
+		if len(chunk)>=MinCompressLength {
+			z,err := c.compress(chunk)
+			if err!=nil { return n,err }
+			/* Incompressible data is sent as is. */
+			if len(z)<len(chunk) { payload,ulen = z,len(chunk) }
+		}
+		pkt := make([]byte,7,7+len(payload))
+		pkt[0],pkt[1],pkt[2] = byte(len(payload)),byte(len(payload)>>8),byte(len(payload)>>16)
+		pkt[3] = c.seq
+		pkt[4],pkt[5],pkt[6] = byte(ulen),byte(ulen>>8),byte(ulen>>16)
+		pkt = append(pkt,payload...)
+		if _,err = c.Conn.Write(pkt); err!=nil { return n,err }
+		c.seq++
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+	return n,nil
+}
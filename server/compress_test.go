@@ -0,0 +1,75 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package server
+
+import "bytes"
+import "io"
+import "math/rand"
+import "net"
+import "testing"
+
+/* A net.Conn, that reads what was written to it. */
+type loopConn struct{
+	net.Conn
+	buf bytes.Buffer
+}
+func (l *loopConn) Read(b []byte) (int,error) { return l.buf.Read(b) }
+func (l *loopConn) Write(b []byte) (int,error) { return l.buf.Write(b) }
+
+func TestCompressedFraming(t *testing.T) {
+	random := make([]byte,70000)
+	rand.New(rand.NewSource(1)).Read(random)
+	payloads := [][]byte{
+		[]byte("short"),
+		bytes.Repeat([]byte("compressible "),10000),
+		random,
+		bytes.Repeat([]byte{'x'},maxCompressedPayload+100),
+	}
+	for _,zstd := range []bool{false,true} {
+		lc := new(loopConn)
+		w := &compConn{Conn:lc,cfg:&Config{},state:compOn,zstd:zstd,level:3}
+		r := &compConn{Conn:lc,cfg:&Config{},state:compOn,zstd:zstd}
+		for _,p := range payloads {
+			if n,err := w.Write(p); err!=nil || n!=len(p) {
+				t.Fatalf("zstd %v: Write(%d bytes) = %d, %v",zstd,len(p),n,err)
+			}
+			got := make([]byte,len(p))
+			if _,err := io.ReadFull(r,got); err!=nil {
+				t.Fatalf("zstd %v: Read(%d bytes): %v",zstd,len(p),err)
+			}
+			if !bytes.Equal(got,p) { t.Errorf("zstd %v: %d bytes differ after the round trip",zstd,len(p)) }
+			if r.seq!=w.seq { t.Errorf("zstd %v: read sequence %d, written %d",zstd,r.seq,w.seq) }
+		}
+	}
+}
+
+func TestCompressionStartsAfterOK(t *testing.T) {
+	lc := new(loopConn)
+	c := &compConn{Conn:lc,cfg:&Config{},state:compAuth,seq:5}
+	auth := []byte{1,0,0,2,0xfe}
+	ok := []byte{7,0,0,4,0,0,0,2,0,0,0}
+	after := []byte{1,0,0,1,0x0e}
+	b := append(append(append([]byte{},auth...),ok...),after...)
+	if n,err := c.Write(b); err!=nil || n!=len(b) { t.Fatalf("Write = %d, %v",n,err) }
+	if c.state!=compOn { t.Fatalf("state %d, want compOn",c.state) }
+	raw := len(auth)+len(ok)
+	if !bytes.Equal(lc.buf.Bytes()[:raw],b[:raw]) { t.Errorf("the packets up to OK were not written as they are") }
+	/* The rest is one uncompressed compressed packet with sequence id 0. */
+	want := append([]byte{byte(len(after)),0,0,0,0,0,0},after...)
+	if !bytes.Equal(lc.buf.Bytes()[raw:],want) { t.Errorf("compressed packet %x, want %x",lc.buf.Bytes()[raw:],want) }
+}
@@ -0,0 +1,248 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package server
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "io"
+import "io/ioutil"
+import "net"
+
+const CapabilityClientLocalFiles = 1<<7
+
+const ERNotAllowedCommand = 1148
+
+const (
+	infileHandshake = iota /* The server greeting is not yet written. */
+	infileResponse         /* Waiting for the client's handshake response. */
+	infileOn
+)
+
+/*
+LOAD DATA LOCAL INFILE, implemented below vitess' mysql.Conn, which buffers its
+writes until the end of the command: The file request (0xFB) is written to the
+connection directly, and the file is read from it directly. The packets of the
+response are renumbered then, as the exchange has used up sequence ids.
+
+The gateway finds the connection through mysql.Conn.RemoteAddr(), which returns
+an infileAddr (see my2any.LocalInfiler).
+*/
+type infileListener struct{
+	net.Listener
+}
+func (l infileListener) Accept() (net.Conn,error) {
+	c,err := l.Listener.Accept()
+	if err!=nil { return nil,err }
+	return &infileConn{Conn:c},nil
+}
+
+type infileConn struct{
+	net.Conn
+	state int
+
+	/* The client has set CLIENT_LOCAL_FILES. */
+	local bool
+
+	/* Data, that is read but not yet returned. */
+	rbuf []byte
+
+	/* The response of the current command is renumbered by delta. */
+	track bool
+	delta byte
+	pkt   []byte
+
+	/* The info string and warning count for the OK packet. */
+	setInfo  bool
+	info     string
+	warnings uint16
+}
+
+type infileAddr struct{
+	net.Addr
+	c *infileConn
+}
+
+func (c *infileConn) RemoteAddr() net.Addr {
+	return infileAddr{c.Conn.RemoteAddr(),c}
+}
+
+/*
+Sends the file request to the client and returns the file. It must be closed,
+before the command ends.
+*/
+func (a infileAddr) RequestFile(name string) (io.ReadCloser,error) {
+	c := a.c
+	if !c.local {
+		return nil,mysql.NewSQLError(ERNotAllowedCommand,"42000","The used command is not allowed with this MySQL version")
+	}
+	/* The command has sequence id 0, the request 1. */
+	n := 1+len(name)
+	pkt := append([]byte{byte(n),byte(n>>8),byte(n>>16),1,0xfb},name...)
+	if _,err := c.Conn.Write(pkt); err!=nil { return nil,err }
+	c.track,c.delta = true,1
+	return &infileReader{c:c},nil
+}
+
+/*
+Sets the info string and the warning count of the OK packet, that ends the command.
+*/
+func (a infileAddr) SetInfo(info string,warnings uint16) {
+	a.c.track,a.c.setInfo,a.c.info,a.c.warnings = true,true,info,warnings
+}
+
+/* Reads a packet (with its header). */
+func readPacket(r io.Reader) ([]byte,error) {
+	hdr := make([]byte,4)
+	if _,err := io.ReadFull(r,hdr); err!=nil { return nil,err }
+	n := int(hdr[0])|int(hdr[1])<<8|int(hdr[2])<<16
+	pkt := make([]byte,4+n)
+	copy(pkt,hdr)
+	if _,err := io.ReadFull(r,pkt[4:]); err!=nil { return nil,err }
+	return pkt,nil
+}
+
+/*
+Reads the handshake response and takes the client's CLIENT_LOCAL_FILES flag.
+*/
+func (c *infileConn) response() (err error) {
+	if c.rbuf,err = readPacket(c.Conn); err!=nil { return }
+	c.state = infileOn
+	if p := c.rbuf; len(p)>=8 {
+		caps := uint32(p[4])|uint32(p[5])<<8|uint32(p[6])<<16|uint32(p[7])<<24
+		c.local = caps&CapabilityClientLocalFiles!=0
+	}
+	return
+}
+
+func (c *infileConn) Read(b []byte) (int,error) {
+	if len(c.rbuf)==0 {
+		if c.state==infileResponse {
+			if err := c.response(); err!=nil { return 0,err }
+		} else {
+			/* vitess reads the next command, the response is complete. */
+			c.track,c.delta,c.setInfo = false,0,false
+			return c.Conn.Read(b)
+		}
+	}
+	n := copy(b,c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n,nil
+}
+
+func (c *infileConn) Write(b []byte) (int,error) {
+	switch {
+	case c.state==infileHandshake:
+		c.state = infileResponse
+		if i := greetingCaps(b); i>=0 {
+			nb := make([]byte,len(b))
+			copy(nb,b)
+			nb[i] |= CapabilityClientLocalFiles
+			b = nb
+		}
+		if _,err := c.Conn.Write(b); err!=nil { return 0,err }
+		return len(b),nil
+	case c.track:
+		return c.writeTracked(b)
+	}
+	return c.Conn.Write(b)
+}
+
+/*
+Writes the complete packets in b (and the ones before), renumbered, and with
+the info in the first one, if it is an OK packet.
+*/
+func (c *infileConn) writeTracked(b []byte) (int,error) {
+	c.pkt = append(c.pkt,b...)
+	for len(c.pkt)>=4 {
+		end := 4+(int(c.pkt[0])|int(c.pkt[1])<<8|int(c.pkt[2])<<16)
+		if end>len(c.pkt) { break }
+		p := c.pkt[:end]
+		if c.setInfo {
+			p = okInfo(p,c.info,c.warnings)
+			c.setInfo = false
+		}
+		p[3] += c.delta
+		if _,err := c.Conn.Write(p); err!=nil { return 0,err }
+		c.pkt = c.pkt[end:]
+	}
+	if len(c.pkt)==0 { c.pkt = nil }
+	return len(b),nil
+}
+
+/*
+Returns the OK packet p with the warning count and the info string, or p,
+if it isn't an OK packet.
+*/
+func okInfo(p []byte,info string,warnings uint16) []byte {
+	if len(p)<5 || p[4]!=0x00 { return p }
+	/* affected rows, last insert id */
+	i := 5
+	for k := 0; k<2 && i<len(p); k++ {
+		switch p[i] {
+		case 0xfc: i += 3
+		case 0xfd: i += 4
+		case 0xfe: i += 9
+		default: i++
+		}
+	}
+	/* status flags, warnings */
+	if i+4>len(p) { return p }
+	np := make([]byte,i+2,i+4+len(info))
+	copy(np,p)
+	np = append(np,byte(warnings),byte(warnings>>8))
+	np = append(np,info...)
+	n := len(np)-4
+	np[0],np[1],np[2] = byte(n),byte(n>>8),byte(n>>16)
+	return np
+}
+
+/*
+Reads the file packets of the client, until the empty one.
+*/
+type infileReader struct{
+	c    *infileConn
+	left int
+	long bool
+	eof  bool
+}
+
+func (r *infileReader) Read(b []byte) (int,error) {
+	for r.left==0 {
+		if r.eof { return 0,io.EOF }
+		hdr := make([]byte,4)
+		if _,err := io.ReadFull(r.c.Conn,hdr); err!=nil {
+			if err==io.EOF { err = io.ErrUnexpectedEOF }
+			return 0,err
+		}
+		r.left = int(hdr[0])|int(hdr[1])<<8|int(hdr[2])<<16
+		r.c.delta = hdr[3]
+		/* An empty packet ends the file, unless it continues a packet of maximum length. */
+		r.eof = r.left==0 && !r.long
+		r.long = r.left==0xffffff
+	}
+	if len(b)>r.left { b = b[:r.left] }
+	n,err := r.c.Conn.Read(b)
+	r.left -= n
+	if err==io.EOF { err = io.ErrUnexpectedEOF }
+	return n,err
+}
+
+/* Skips the rest of the file, the client is waiting for the response. */
+func (r *infileReader) Close() error {
+	_,err := io.Copy(ioutil.Discard,r)
+	return err
+}
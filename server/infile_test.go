@@ -0,0 +1,78 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+
+package server
+
+import "bytes"
+import "io/ioutil"
+import "net"
+import "testing"
+
+/* A net.Conn, that reads what the client sent and records what the server wrote. */
+type scriptConn struct{
+	net.Conn
+	in  bytes.Buffer
+	out bytes.Buffer
+}
+func (s *scriptConn) Read(b []byte) (int,error) { return s.in.Read(b) }
+func (s *scriptConn) Write(b []byte) (int,error) { return s.out.Write(b) }
+func (s *scriptConn) RemoteAddr() net.Addr { return nil }
+
+func TestLocalInfileExchange(t *testing.T) {
+	sc := new(scriptConn)
+	c := &infileConn{Conn:sc,state:infileOn,local:true}
+	/* The file in two packets and the empty one, after the request (sequence id 1). */
+	sc.in.Write([]byte{4,0,0,2,'a',',','b','\n'})
+	sc.in.Write([]byte{2,0,0,3,'c','\n'})
+	sc.in.Write([]byte{0,0,0,4})
+	sc.in.Write([]byte{1,0,0,0,0x0e})
+
+	lf := c.RemoteAddr().(infileAddr)
+	r,err := lf.RequestFile("a.csv")
+	if err!=nil { t.Fatal(err) }
+	data,err := ioutil.ReadAll(r)
+	if err!=nil { t.Fatal(err) }
+	if string(data)!="a,b\nc\n" { t.Errorf("file %q",data) }
+	if err = r.Close(); err!=nil { t.Fatal(err) }
+	if want := []byte{6,0,0,1,0xfb,'a','.','c','s','v'}; !bytes.Equal(sc.out.Bytes(),want) {
+		t.Errorf("request %x, want %x",sc.out.Bytes(),want)
+	}
+	sc.out.Reset()
+
+	/* vitess writes the OK packet with sequence id 1, in two pieces. */
+	lf.SetInfo("Records: 2",1)
+	ok := []byte{7,0,0,1,0,2,0,2,0,0,0}
+	if _,err = c.Write(ok[:6]); err!=nil { t.Fatal(err) }
+	if sc.out.Len()!=0 { t.Errorf("an incomplete packet was written") }
+	if _,err = c.Write(ok[6:]); err!=nil { t.Fatal(err) }
+	want := append([]byte{17,0,0,5,0,2,0,2,0,1,0},"Records: 2"...)
+	if !bytes.Equal(sc.out.Bytes(),want) { t.Errorf("OK packet %x, want %x",sc.out.Bytes(),want) }
+
+	/* The next command is passed through. */
+	b := make([]byte,5)
+	if n,_ := c.Read(b); n!=5 || c.track { t.Errorf("read %d bytes, tracking %v",n,c.track) }
+	sc.out.Reset()
+	c.Write(ok)
+	if !bytes.Equal(sc.out.Bytes(),ok) { t.Errorf("packet %x, want %x",sc.out.Bytes(),ok) }
+}
+
+func TestLocalInfileRefused(t *testing.T) {
+	c := &infileConn{Conn:new(scriptConn),state:infileOn}
+	if _,err := c.RemoteAddr().(infileAddr).RequestFile("a"); err==nil {
+		t.Errorf("no error without CLIENT_LOCAL_FILES")
+	}
+}
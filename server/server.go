@@ -0,0 +1,197 @@
+/*
+   Copyright 2018 Simon Schmidt
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Listener setup for the gateways (my2any and generaldb).
+
+Both gateways are plain mysql.Handler implementations. This package creates
+the mysql.Listener for them, over TCP (optionally with TLS) or a Unix domain socket.
+*/
+package server
+
+import "gopkg.in/src-d/go-vitess.v0/mysql"
+import "crypto/tls"
+import "crypto/x509"
+import "io/ioutil"
+import "net"
+import "os"
+import "fmt"
+
+type Config struct{
+	/* "tcp" (default) or "unix". */
+	Network string
+
+	/* host:port for "tcp", the socket path for "unix". */
+	Address string
+
+	/* File mode of the socket file. Defaults to 0777, like mysqld. */
+	SocketMode os.FileMode
+
+	/* PEM encoded certificate and key. If set, CLIENT_SSL is advertised. */
+	CertFile string
+	KeyFile  string
+
+	/* Optional PEM encoded CA bundle, used to verify client certificates. */
+	CAFile string
+
+	/* Reject clients, that don't present a valid certificate. (Requires CAFile) */
+	RequireClientCert bool
+
+	/*
+	Allows clear text authentication methods on TCP connections without TLS.
+	Unix domain sockets always allow them, as they never leave the host.
+	*/
+	AllowClearTextWithoutTLS bool
+
+	/*
+	Offers the compressed protocol (CLIENT_COMPRESS, zlib) to the clients. With
+	CompressZstd, they can choose zstd as well (MySQL 8.0.18 or later clients,
+	which request the level). Compression can't be combined with TLS.
+	*/
+	Compress     bool
+	CompressZstd bool
+
+	/* The zlib level (1-9), 0 for the default. */
+	CompressLevel int
+
+	/*
+	Offers LOAD DATA LOCAL INFILE (CLIENT_LOCAL_FILES) to the clients, which read
+	the file from their disk then. Like compression, it can't be combined with TLS.
+	*/
+	LocalInfile bool
+
+	ServerVersion string
+}
+
+func (cfg *Config) network() string {
+	if cfg.Network=="" { return "tcp" }
+	return cfg.Network
+}
+
+func (cfg *Config) IsUnix() bool {
+	return cfg.network()=="unix"
+}
+
+/*
+Creates the *tls.Config from the configured certificates. Returns nil,nil, if
+no certificate is configured.
+*/
+func (cfg *Config) TLSConfig() (*tls.Config,error) {
+	if cfg.CertFile=="" && cfg.KeyFile=="" { return nil,nil }
+	cert,err := tls.LoadX509KeyPair(cfg.CertFile,cfg.KeyFile)
+	if err!=nil { return nil,err }
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CAFile!="" {
+		data,err := ioutil.ReadFile(cfg.CAFile)
+		if err!=nil { return nil,err }
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil,fmt.Errorf("no certificates found in %q",cfg.CAFile)
+		}
+		tc.ClientCAs = pool
+		if cfg.RequireClientCert {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tc.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if cfg.RequireClientCert {
+		return nil,fmt.Errorf("RequireClientCert needs a CAFile")
+	}
+	return tc,nil
+}
+
+/*
+Creates a listener for the given Handler (a *my2any.Gateway or a *generaldb.Gateway).
+
+The caller must call Accept() on the returned listener.
+*/
+func Listen(cfg *Config,auth mysql.AuthServer,h mysql.Handler) (*mysql.Listener,error) {
+	if cfg.IsUnix() {
+		/* Remove a stale socket, left behind by a previous process. */
+		if fi,err := os.Lstat(cfg.Address); err==nil && fi.Mode()&os.ModeSocket!=0 {
+			os.Remove(cfg.Address)
+		}
+	}
+
+	tc,err := cfg.TLSConfig()
+	if err!=nil { return nil,err }
+
+	var lst *mysql.Listener
+	if cfg.Compress || cfg.CompressZstd || cfg.LocalInfile {
+		/* On Unix domain sockets as well: a certificate in the config asks for TLS. */
+		if tc!=nil && cfg.LocalInfile { return nil,fmt.Errorf("LOCAL INFILE can't be combined with TLS") }
+		if tc!=nil { return nil,fmt.Errorf("compression can't be combined with TLS") }
+		nl,err := net.Listen(cfg.network(),cfg.Address)
+		if err!=nil { return nil,err }
+		var l net.Listener = nl
+		if cfg.Compress || cfg.CompressZstd { l = compListener{l,cfg} }
+		/* Above the compression, which is transparent to it. */
+		if cfg.LocalInfile { l = infileListener{l} }
+		lst,err = mysql.NewFromListener(l,auth,h)
+		if err!=nil {
+			nl.Close()
+			return nil,err
+		}
+	} else {
+		lst,err = mysql.NewListener(cfg.network(),cfg.Address,auth,h)
+		if err!=nil { return nil,err }
+	}
+
+	if cfg.IsUnix() {
+		mode := cfg.SocketMode
+		if mode==0 { mode = 0777 }
+		if err = os.Chmod(cfg.Address,mode); err!=nil {
+			lst.Close()
+			return nil,err
+		}
+		lst.AllowClearTextWithoutTLS = true
+	} else {
+		lst.TLSConfig = tc
+		lst.AllowClearTextWithoutTLS = cfg.AllowClearTextWithoutTLS
+	}
+	if cfg.ServerVersion!="" {
+		lst.ServerVersion = cfg.ServerVersion
+	}
+	return lst,nil
+}
+
+/*
+Listens on multiple addresses (for example a TCP port and a Unix domain socket)
+and calls Accept() on each of them. Blocks until all listeners are closed.
+*/
+func ListenAndServe(auth mysql.AuthServer,h mysql.Handler,cfgs ...*Config) error {
+	lsts := make([]*mysql.Listener,0,len(cfgs))
+	for _,cfg := range cfgs {
+		lst,err := Listen(cfg,auth,h)
+		if err!=nil {
+			for _,l := range lsts { l.Close() }
+			return err
+		}
+		lsts = append(lsts,lst)
+	}
+	done := make(chan int,len(lsts))
+	for _,l := range lsts {
+		go func(l *mysql.Listener) {
+			l.Accept()
+			done <- 1
+		}(l)
+	}
+	for range lsts { <- done }
+	return nil
+}